@@ -0,0 +1,63 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterDatabaseInstanceSpec defines the desired state of ClusterDatabaseInstance
+type ClusterDatabaseInstanceSpec struct {
+	DatabaseInstanceSpec `json:",inline"`
+	// Namespaces allowed to create databases on the instance, all namespaces are allowed when omitted.
+	// +optional
+	AllowedNamespaces *metav1.LabelSelector `json:"allowedNamespaces,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+
+// ClusterDatabaseInstance is the Schema for the clusterdatabaseinstances API
+type ClusterDatabaseInstance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterDatabaseInstanceSpec `json:"spec,omitempty"`
+	Status DatabaseInstanceStatus      `json:"status,omitempty"`
+}
+
+func (in *ClusterDatabaseInstance) GetSpec() *DatabaseInstanceSpec {
+	return &in.Spec.DatabaseInstanceSpec
+}
+
+func (in *ClusterDatabaseInstance) GetStatus() *DatabaseInstanceStatus {
+	return &in.Status
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterDatabaseInstanceList contains a list of ClusterDatabaseInstance
+type ClusterDatabaseInstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterDatabaseInstance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterDatabaseInstance{}, &ClusterDatabaseInstanceList{})
+}
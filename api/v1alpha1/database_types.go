@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -30,17 +31,236 @@ type DatabaseSpec struct {
 
 	DatabaseInstanceRef DatabaseInstanceRef `json:"databaseInstanceRef"`
 
+	// Name of the database and its user on the instance, rendered from the
+	// databaseNameTemplate of the instance when empty. It should be an identifier the engine
+	// accepts without quoting and can't be changed once provisioned, a change is reported in
+	// the NameChanged condition.
+	// +optional
+	DatabaseName string `json:"databaseName,omitempty"`
+
 	// +optional
 	SecretName string `json:"secretName,omitempty"`
 
+	// Additional keys of the secret rendered from Go templates.
+	// +optional
+	SecretTemplate *SecretTemplate `json:"secretTemplate,omitempty"`
+
+	// Copies of the secret in other namespaces, the namespaces should be allowed by a ClusterDatabaseInstance.
+	// A DatabaseInstance allows copies in its own namespace only.
+	// +optional
+	SecretTargets []SecretTarget `json:"secretTargets,omitempty"`
+
+	// Writes the credentials to Vault, next to or instead of the secret.
+	// +optional
+	Vault *VaultCredentials `json:"vault,omitempty"`
+
 	// +optional
 	Cleanup bool `json:"cleanup,omitempty"`
 
 	// +optional
 	Properties map[string]string `json:"properties,omitempty"`
+
+	// +optional
+	Quota *DatabaseQuota `json:"quota,omitempty"`
+
+	// Database to copy the data from when this one is created.
+	// +optional
+	Source *DatabaseSource `json:"source,omitempty"`
+
+	// Time after creation when the object is deleted, the expiry is extended
+	// by a duration in the databaser.slamdev.github.com/extend-ttl annotation.
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// Time when the object is deleted, takes precedence over TTL.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// What to do when the database or the user already exists on the instance before the object is
+	// created, ifExists takes them over and never refuses to provision it. Databases and users of the
+	// system and of the admin are never taken over.
+	// +kubebuilder:validation:Enum=ifExists;never
+	// +kubebuilder:default=ifExists
+	// +optional
+	Adopt AdoptPolicy `json:"adopt,omitempty"`
+
+	// Makes the generated user the owner of an adopted database and its objects.
+	// +optional
+	ResetOwner bool `json:"resetOwner,omitempty"`
+
+	// Secret in the namespace of the database holding the password of a user that exists before the
+	// object is created. The user is adopted with it and its password is never changed, without it the
+	// password is taken from the secret or Vault of the database.
+	// +optional
+	PasswordSecretKeyRef *SecretKeyRef `json:"passwordSecretKeyRef,omitempty"`
+
+	// Schemas created in a postgres database, the generated user has them on its search_path in the
+	// listed order.
+	// +optional
+	Schemas []Schema `json:"schemas,omitempty"`
+
+	// What to do with a schema removed from the list, retain keeps it with its objects and drop
+	// drops them.
+	// +kubebuilder:validation:Enum=retain;drop
+	// +kubebuilder:default=retain
+	// +optional
+	SchemaRemoval SchemaRemovalPolicy `json:"schemaRemoval,omitempty"`
+
+	// Extensions the admin installs in a postgres database since the generated user can't.
+	// +optional
+	Extensions []Extension `json:"extensions,omitempty"`
+
+	// Script run once after the operator creates the database, e.g. to load fixtures. An existing
+	// database taken over by the operator is not initialized.
+	// +optional
+	InitSQL *InitSQL `json:"initSQL,omitempty"`
+}
+
+type AdoptPolicy string
+
+const (
+	AdoptIfExists AdoptPolicy = "ifExists"
+	AdoptNever    AdoptPolicy = "never"
+)
+
+type Schema struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Role owning the schema, the generated user by default.
+	// +optional
+	Owner string `json:"owner,omitempty"`
+
+	// Existing roles allowed to read the tables of the schema, including the ones created later.
+	// +optional
+	ReadOnlyUsers []string `json:"readOnlyUsers,omitempty"`
+}
+
+type Extension struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Version to install or update to, the default version of the server when empty.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Schema of the extension objects, the first one on the admin search path when empty.
+	// +optional
+	Schema string `json:"schema,omitempty"`
+}
+
+// InitSQL is either an inline script or a key of a ConfigMap
+type InitSQL struct {
+	// +optional
+	SQL string `json:"sql,omitempty"`
+
+	// ConfigMap in the namespace of the database holding the script.
+	// +optional
+	ConfigMapKeyRef *ConfigMapKeyRef `json:"configMapKeyRef,omitempty"`
+
+	// Who runs the script, the generated user owning the created objects or the admin.
+	// +kubebuilder:validation:Enum=user;admin
+	// +kubebuilder:default=user
+	// +optional
+	RunAs InitSQLRunAs `json:"runAs,omitempty"`
+}
+
+type ConfigMapKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+type SecretKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+type InitSQLRunAs string
+
+const (
+	InitSQLRunAsUser  InitSQLRunAs = "user"
+	InitSQLRunAsAdmin InitSQLRunAs = "admin"
+)
+
+type SchemaRemovalPolicy string
+
+const (
+	SchemaRemovalRetain SchemaRemovalPolicy = "retain"
+	SchemaRemovalDrop   SchemaRemovalPolicy = "drop"
+)
+
+// DatabaseSource is a database on the same instance cloned into the new one
+type DatabaseSource struct {
+	// Database in the same namespace to clone.
+	DatabaseRef DatabaseRef `json:"databaseRef"`
+	// Columns scrubbed in the clone.
+	// +optional
+	Masking []MaskingRule `json:"masking,omitempty"`
+}
+
+// MaskingRule replaces all values of a column
+type MaskingRule struct {
+	// Table of the column, may be qualified with a schema on Postgres.
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	// Replacement of the values, null on Postgres and the default value of the type on ClickHouse when not set.
+	// +optional
+	Value *string `json:"value,omitempty"`
+}
+
+// SecretTemplate shapes the secret for its consumer, the rendered keys are added next to
+// type, provider, host, port, database, username and password that are always present.
+type SecretTemplate struct {
+	// Go templates by key with .Host, .Port, .Database, .Username, .Password and .TLS.CA, .TLS.Cert, .TLS.Key,
+	// e.g. DATABASE_URL: postgres://{{ .Username }}:{{ urlquery .Password }}@{{ .Host }}:{{ .Port }}/{{ .Database }}.
+	Data map[string]string `json:"data"`
+
+	// Secret with ca.crt, tls.crt and tls.key keys exposed to the templates.
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+}
+
+type SecretTarget struct {
+	Namespace string `json:"namespace"`
+	// Name of the copy, defaults to the name of the secret.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+// VaultCredentials is a path in the KV engine configured in the operator, kept below the namespace of the database
+type VaultCredentials struct {
+	// Path below the namespace, defaults to the name of the database.
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Keeps the credentials in Vault only. Backups, restores and secret targets need the secret and don't work without it.
+	// +optional
+	SkipSecret bool `json:"skipSecret,omitempty"`
+}
+
+// DatabaseQuota limits the storage the database is allowed to use
+type DatabaseQuota struct {
+	MaxBytes resource.Quantity `json:"maxBytes"`
+	// Percentage of MaxBytes after which a warning is reported.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:default=80
+	// +optional
+	WarningThreshold int `json:"warningThreshold,omitempty"`
+	// Makes the database user read only while the quota is exceeded. On Postgres the privileges of the user to
+	// write its tables and to create objects are revoked, default_transaction_read_only is set as a hint only
+	// since sessions can turn it off. On ClickHouse the readonly settings profile, which should exist on the
+	// server, is added to the user and dropped again once the usage is back within the quota.
+	// +optional
+	Enforce bool `json:"enforce,omitempty"`
 }
 
 type DatabaseInstanceRef struct {
+	// Kind of the instance, a namespaced DatabaseInstance from the same namespace or a ClusterDatabaseInstance.
+	// +kubebuilder:validation:Enum=DatabaseInstance;ClusterDatabaseInstance
+	// +kubebuilder:default=DatabaseInstance
+	// +optional
+	Kind string `json:"kind,omitempty"`
 	Name string `json:"name"`
 }
 
@@ -50,10 +270,69 @@ type DatabaseStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	Phase     Phase  `json:"phase,omitempty"`
 	LastError string `json:"lastError,omitempty"`
+	// Name of the database and its user on the instance.
+	// +optional
+	DatabaseName string `json:"databaseName,omitempty"`
+	// User created by the operator, only its password is rotated and only it is dropped on cleanup.
+	// +optional
+	User string `json:"user,omitempty"`
+	// Secret with the credentials in the servicebinding.io format.
+	// +optional
+	Binding *ServiceBindingReference `json:"binding,omitempty"`
+	// +optional
+	Usage *DatabaseUsage `json:"usage,omitempty"`
+	// +optional
+	QuotaEnforced bool `json:"quotaEnforced,omitempty"`
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// Schemas as they are provisioned, with the resolved owner.
+	// +optional
+	Schemas []Schema `json:"schemas,omitempty"`
+	// Extensions of the spec with their installed version.
+	// +optional
+	Extensions []InstalledExtension `json:"extensions,omitempty"`
+}
+
+type InstalledExtension struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// ExtendTTLAnnotation holds a duration, e.g. 24h, added to the expiry of the database
+const ExtendTTLAnnotation = "databaser.slamdev.github.com/extend-ttl"
+
+const (
+	// ConditionQuotaExceeded is true when the database is bigger than its quota allows
+	ConditionQuotaExceeded = "QuotaExceeded"
+	// ConditionCloned is true when the database is cloned from its source and handed over to its user
+	ConditionCloned = "Cloned"
+	// ConditionAdopted is true when the database existed before and is taken over
+	ConditionAdopted = "Adopted"
+	// ConditionNameChanged is true when the spec asks for another name than the provisioned one, the database is not renamed
+	ConditionNameChanged = "NameChanged"
+	// ConditionInitialized is true once the init SQL succeeds, a failed one is retried on the next spec change only
+	ConditionInitialized = "Initialized"
+)
+
+// ServiceBindingReference points at the binding secret of a provisioned service
+type ServiceBindingReference struct {
+	Name string `json:"name"`
+}
+
+// DatabaseUsage is the storage and activity of the database collected from the instance
+type DatabaseUsage struct {
+	SizeBytes   int64       `json:"sizeBytes"`
+	Tables      int64       `json:"tables"`
+	Connections int64       `json:"connections"`
+	CollectedAt metav1.Time `json:"collectedAt"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Expires",type=date,JSONPath=`.status.expiresAt`
 
 // Database is the Schema for the databases API
 type Database struct {
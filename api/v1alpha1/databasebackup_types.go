@@ -0,0 +1,126 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DatabaseBackupSpec defines the desired state of DatabaseBackup
+type DatabaseBackupSpec struct {
+	// Database in the same namespace to back up.
+	DatabaseRef DatabaseRef `json:"databaseRef"`
+
+	Storage BackupStorage `json:"storage"`
+
+	// Image with pg_dump or clickhouse-client matching the server version.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Whether the uploaded backup is deleted from the storage together with the object.
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+type DeletionPolicy string
+
+const (
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+)
+
+type DatabaseRef struct {
+	Name string `json:"name"`
+}
+
+// BackupStorage is the location backups are uploaded to
+type BackupStorage struct {
+	S3 S3Storage `json:"s3"`
+}
+
+// S3Storage is a bucket in AWS S3 or any S3 compatible object storage
+type S3Storage struct {
+	Bucket string `json:"bucket"`
+
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Endpoint of an S3 compatible storage, e.g. http://minio:9000.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Secret with accessKeyId and secretAccessKey keys.
+	CredentialsSecretName string `json:"credentialsSecretName"`
+}
+
+// DatabaseBackupStatus defines the observed state of DatabaseBackup
+type DatabaseBackupStatus struct {
+	Phase     Phase  `json:"phase,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+	// URL of the uploaded backup.
+	// +optional
+	Location string `json:"location,omitempty"`
+	// +optional
+	SizeBytes int64 `json:"sizeBytes,omitempty"`
+	// SHA-256 checksum of the uploaded backup.
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+}
+
+const (
+	PhaseRunning   Phase = "running"
+	PhaseCompleted Phase = "completed"
+	PhaseFailed    Phase = "failed"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseRef.name`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Size",type=integer,JSONPath=`.status.sizeBytes`
+// +kubebuilder:printcolumn:name="Completed",type=date,JSONPath=`.status.completedAt`
+
+// DatabaseBackup is the Schema for the databasebackups API
+type DatabaseBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseBackupSpec   `json:"spec,omitempty"`
+	Status DatabaseBackupStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DatabaseBackupList contains a list of DatabaseBackup
+type DatabaseBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DatabaseBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DatabaseBackup{}, &DatabaseBackupList{})
+}
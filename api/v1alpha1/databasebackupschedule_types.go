@@ -0,0 +1,89 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DatabaseBackupScheduleSpec defines the desired state of DatabaseBackupSchedule
+type DatabaseBackupScheduleSpec struct {
+	// Cron expression, e.g. "0 3 * * *".
+	Schedule string `json:"schedule"`
+
+	// Template of the backups created on schedule.
+	BackupTemplate DatabaseBackupSpec `json:"backupTemplate"`
+
+	// +optional
+	Retention BackupRetention `json:"retention,omitempty"`
+
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// BackupRetention defines which scheduled backups are kept, backups not matching any rule are
+// deleted together with their uploads. All backups are kept when no rule is set.
+type BackupRetention struct {
+	// Number of the most recent backups to keep.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepLast int `json:"keepLast,omitempty"`
+
+	// Number of days for which the most recent backup of a day is kept.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepDaily int `json:"keepDaily,omitempty"`
+}
+
+// DatabaseBackupScheduleStatus defines the observed state of DatabaseBackupSchedule
+type DatabaseBackupScheduleStatus struct {
+	Phase     Phase  `json:"phase,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// Name of the most recently created backup.
+	// +optional
+	LastBackup string `json:"lastBackup,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`
+// +kubebuilder:printcolumn:name="Last Backup",type=string,JSONPath=`.status.lastBackup`
+// +kubebuilder:printcolumn:name="Last Schedule",type=date,JSONPath=`.status.lastScheduleTime`
+
+// DatabaseBackupSchedule is the Schema for the databasebackupschedules API
+type DatabaseBackupSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseBackupScheduleSpec   `json:"spec,omitempty"`
+	Status DatabaseBackupScheduleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DatabaseBackupScheduleList contains a list of DatabaseBackupSchedule
+type DatabaseBackupScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DatabaseBackupSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DatabaseBackupSchedule{}, &DatabaseBackupScheduleList{})
+}
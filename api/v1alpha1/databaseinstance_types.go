@@ -33,6 +33,62 @@ type DatabaseInstanceSpec struct {
 
 	// +optional
 	Clikhouse *ClikhouseSpec `json:"clickhouse,omitempty"`
+
+	// +optional
+	Limits *InstanceLimits `json:"limits,omitempty"`
+
+	// Go template of database names with .Namespace and .Name of the Database, e.g. {{ .Namespace }}_{{ .Name }}.
+	// The result is sanitized to the identifier rules of the engine. Defaults to {{ .Name }}.
+	// +optional
+	DatabaseNameTemplate string `json:"databaseNameTemplate,omitempty"`
+
+	// Namespaces databases are allowed to copy their secrets to, honored by a ClusterDatabaseInstance only.
+	// Databases of a DatabaseInstance can copy their secrets within its namespace only.
+	// +optional
+	SecretTargetNamespaces []string `json:"secretTargetNamespaces,omitempty"`
+
+	// Reports databases and users on the instance that are not managed by any Database.
+	// +optional
+	Inventory *InventorySpec `json:"inventory,omitempty"`
+}
+
+type InventorySpec struct {
+	// Creates a Database adopting the unmanaged databases selected by name.
+	// +optional
+	AutoAdopt *AutoAdoptSpec `json:"autoAdopt,omitempty"`
+}
+
+// AutoAdoptSpec selects the unmanaged databases to adopt by name patterns, databases on the server carry
+// no labels to select them by. The created Database objects get the labels instead.
+type AutoAdoptSpec struct {
+	// Shell patterns of the names of the adopted databases, e.g. orders or app_*. Only names that are
+	// valid object names and identifiers needing no quoting are adopted, databases of the system and
+	// of the admin never are.
+	// +kubebuilder:validation:MinItems=1
+	Databases []string `json:"databases"`
+
+	// Namespace of the created databases. Defaults to the namespace of a DatabaseInstance and
+	// is required for a ClusterDatabaseInstance.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Labels of the created databases, to select them later on.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// InstanceLimits restricts how many databases can be provisioned on the instance, zero means unlimited
+type InstanceLimits struct {
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxDatabases int `json:"maxDatabases,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxDatabasesPerNamespace int `json:"maxDatabasesPerNamespace,omitempty"`
+	// Maximum number of users granted access to a database, its own user and the owners and readers of its schemas.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxUsersPerDatabase int `json:"maxUsersPerDatabase,omitempty"`
 }
 
 type PostgresSpec struct {
@@ -43,8 +99,26 @@ type PostgresSpec struct {
 
 	// +optional
 	AuthDBRef *ParamRef `json:"authDbRef,omitempty"`
+
+	// How the admin user logs in, with the password or with short lived tokens of the token provider.
+	// +kubebuilder:validation:Enum=password;iamToken
+	// +kubebuilder:default=password
+	// +optional
+	Auth PostgresAuth `json:"auth,omitempty"`
+
+	// Token provider registered in the operator, required by the iamToken auth, e.g. cloudsql
+	// registered with the --cloudsql-token-provider flag.
+	// +optional
+	TokenProvider string `json:"tokenProvider,omitempty"`
 }
 
+type PostgresAuth string
+
+const (
+	PostgresAuthPassword PostgresAuth = "password"
+	PostgresAuthIAMToken PostgresAuth = "iamToken"
+)
+
 type ClikhouseSpec struct {
 	SqlParams `json:",inline"`
 }
@@ -73,15 +147,50 @@ type SqlParams struct {
 
 	// +optional
 	PortRef *ParamRef `json:"portRef,omitempty"`
+
+	// Bastion the operator connects through when the host is reachable only from a private network.
+	// The tunnel is open in the operator only, so backups, restores and migrations from images, which
+	// run in jobs, are refused for the instance.
+	// +optional
+	SSHTunnel *SSHTunnel `json:"sshTunnel,omitempty"`
+}
+
+type SSHTunnel struct {
+	// Host of the bastion.
+	Host string `json:"host"`
+
+	// +kubebuilder:default=22
+	// +optional
+	Port int `json:"port,omitempty"`
+
+	// User the operator logs in to the bastion as.
+	User string `json:"user"`
+
+	// Private key the operator logs in with, the ssh-privatekey key of a kubernetes.io/ssh-auth
+	// secret is read by default.
+	PrivateKeyRef ParamRef `json:"privateKeyRef"`
+
+	// known_hosts content the bastion key is verified against.
+	KnownHostsRef ParamRef `json:"knownHostsRef"`
 }
 
 type ParamRef struct {
+	// Secret provider registered in the operator, e.g. file, reading the value by Name and Key
+	// instead of an object in the cluster.
+	// +optional
+	Provider string `json:"provider,omitempty"`
+
+	// API version of the referent, defaults to v1.
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+
 	// Kind of the referent.
 	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds
 	// +optional
 	Kind string `json:"kind,omitempty"`
 
-	// Namespace of the referent.
+	// Namespace of the referent. Defaults to the namespace of a DatabaseInstance and
+	// is required for a ClusterDatabaseInstance.
 	// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/namespaces/
 	// +optional
 	Namespace string `json:"namespace,omitempty"`
@@ -91,9 +200,16 @@ type ParamRef struct {
 	// +optional
 	Name string `json:"name,omitempty"`
 
-	// Data key.
+	// Data key of a ConfigMap or Secret.
 	// +optional
 	Key string `json:"key,omitempty"`
+
+	// JSONPath of the value in the referent, e.g. {.spec.clusterIP}, required for kinds other than
+	// ConfigMap and Secret. Values of a Secret are base64 encoded when read this way. The operator
+	// reads only Services besides them, other kinds should be granted in the field-reader-role of
+	// config/rbac.
+	// +optional
+	FieldPath string `json:"fieldPath,omitempty"`
 }
 
 // DatabaseInstanceStatus defines the observed state of DatabaseInstance
@@ -102,12 +218,34 @@ type DatabaseInstanceStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	Phase     string `json:"phase,omitempty"`
 	LastError string `json:"lastError,omitempty"`
+	// Number of databases provisioned on the instance.
+	// +optional
+	Databases int `json:"databases,omitempty"`
+	// Number of databases provisioned on the instance by namespace.
+	// +optional
+	DatabasesPerNamespace map[string]int `json:"databasesPerNamespace,omitempty"`
+	// Number of users granted access to each database provisioned on the instance, by namespace/name of the Database.
+	// +optional
+	UsersPerDatabase map[string]int `json:"usersPerDatabase,omitempty"`
+	// Databases and users on the instance not managed by any Database.
+	// +optional
+	Unmanaged *InstanceInventory `json:"unmanaged,omitempty"`
+}
+
+// InstanceInventory lists objects found on the instance
+type InstanceInventory struct {
+	// +optional
+	Databases []string `json:"databases,omitempty"`
+	// +optional
+	Users       []string    `json:"users,omitempty"`
+	CollectedAt metav1.Time `json:"collectedAt"`
 }
 
 type Phase string
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced
 
 // DatabaseInstance is the Schema for the databaseinstances API
 type DatabaseInstance struct {
@@ -118,6 +256,14 @@ type DatabaseInstance struct {
 	Status DatabaseInstanceStatus `json:"status,omitempty"`
 }
 
+func (in *DatabaseInstance) GetSpec() *DatabaseInstanceSpec {
+	return &in.Spec
+}
+
+func (in *DatabaseInstance) GetStatus() *DatabaseInstanceStatus {
+	return &in.Status
+}
+
 // +kubebuilder:object:root=true
 
 // DatabaseInstanceList contains a list of DatabaseInstance
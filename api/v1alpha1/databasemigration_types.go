@@ -0,0 +1,103 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DatabaseMigrationSpec defines the desired state of DatabaseMigration
+type DatabaseMigrationSpec struct {
+	// Database in the same namespace the migrations are applied to with the credentials of its user.
+	DatabaseRef DatabaseRef `json:"databaseRef"`
+
+	Source MigrationSource `json:"source"`
+
+	// Table keeping the applied versions, created in the database when missing.
+	// +kubebuilder:default=schema_migrations
+	// +optional
+	TrackingTable string `json:"trackingTable,omitempty"`
+}
+
+// MigrationSource holds SQL files named like 0001_create_users.sql, they are applied in the order of the versions.
+type MigrationSource struct {
+	// ConfigMap in the same namespace with a key per file, applied by the operator itself.
+	// +optional
+	ConfigMap *ConfigMapMigrationSource `json:"configMap,omitempty"`
+
+	// Directory of an image with a shell, applied by a job.
+	// +optional
+	Image *ImageMigrationSource `json:"image,omitempty"`
+}
+
+type ConfigMapMigrationSource struct {
+	Name string `json:"name"`
+}
+
+type ImageMigrationSource struct {
+	Image string `json:"image"`
+
+	// +kubebuilder:default=/migrations
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// DatabaseMigrationStatus defines the observed state of DatabaseMigration
+type DatabaseMigrationStatus struct {
+	Phase     Phase  `json:"phase,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+	// Latest version in the tracking table.
+	// +optional
+	AppliedVersion string `json:"appliedVersion,omitempty"`
+	// Generation of the spec the phase refers to, a failed migration is retried once the spec
+	// or the content of the source changes.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Checksum of the ConfigMap files the phase refers to.
+	// +optional
+	SourceChecksum string `json:"sourceChecksum,omitempty"`
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseRef.name`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.appliedVersion`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// DatabaseMigration is the Schema for the databasemigrations API
+type DatabaseMigration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseMigrationSpec   `json:"spec,omitempty"`
+	Status DatabaseMigrationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DatabaseMigrationList contains a list of DatabaseMigration
+type DatabaseMigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DatabaseMigration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DatabaseMigration{}, &DatabaseMigrationList{})
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DatabaseRestoreSpec defines the desired state of DatabaseRestore
+type DatabaseRestoreSpec struct {
+	Source RestoreSource `json:"source"`
+
+	// Database in the same namespace to restore into.
+	DatabaseRef DatabaseRef `json:"databaseRef"`
+
+	// Spec of the target database to create when it doesn't exist.
+	// +optional
+	DatabaseTemplate *DatabaseSpec `json:"databaseTemplate,omitempty"`
+
+	// Image with pg_restore or clickhouse-client matching the server version.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// RestoreSource is either a completed DatabaseBackup or a location in an object storage
+type RestoreSource struct {
+	// Completed backup in the same namespace.
+	// +optional
+	BackupRef *DatabaseBackupRef `json:"backupRef,omitempty"`
+
+	// +optional
+	S3 *S3Source `json:"s3,omitempty"`
+}
+
+type DatabaseBackupRef struct {
+	Name string `json:"name"`
+}
+
+// S3Source is a backup in AWS S3 or any S3 compatible object storage
+type S3Source struct {
+	// URL of the backup, e.g. s3://backups/team/app/backup.dump.
+	URL string `json:"url"`
+
+	// Endpoint of an S3 compatible storage, e.g. http://minio:9000.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Secret with accessKeyId and secretAccessKey keys.
+	CredentialsSecretName string `json:"credentialsSecretName"`
+}
+
+// DatabaseRestoreStatus defines the observed state of DatabaseRestore
+type DatabaseRestoreStatus struct {
+	Phase     Phase  `json:"phase,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseRef.name`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Completed",type=date,JSONPath=`.status.completedAt`
+
+// DatabaseRestore is the Schema for the databaserestores API
+type DatabaseRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseRestoreSpec   `json:"spec,omitempty"`
+	Status DatabaseRestoreStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DatabaseRestoreList contains a list of DatabaseRestore
+type DatabaseRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DatabaseRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DatabaseRestore{}, &DatabaseRestoreList{})
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// ClusterDatabaseInstanceReconciler reconciles a ClusterDatabaseInstance object
+type ClusterDatabaseInstanceReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=clusterdatabaseinstances,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=clusterdatabaseinstances/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=clusterdatabaseinstances/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databases,verbs=get;list;watch;create
+
+// Reconcile checks the connection to the cluster wide instance the same way it is done for a DatabaseInstance.
+func (r *ClusterDatabaseInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = r.Log.WithValues("clusterdatabaseinstance", req.NamespacedName)
+
+	instance := &databaserv1alpha1.ClusterDatabaseInstance{}
+	if err := r.Client.Get(ctx, req.NamespacedName, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	return reconcileInstance(ctx, r.Client, r.Recorder, instance)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterDatabaseInstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databaserv1alpha1.ClusterDatabaseInstance{}).
+		Watches(&source.Kind{Type: &databaserv1alpha1.Database{}}, handler.EnqueueRequestsFromMapFunc(instanceRequest(true)), builder.WithPredicates(databaseCountChanged)).
+		Complete(r)
+}
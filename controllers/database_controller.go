@@ -18,37 +18,54 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"github.com/slamdev/databaser/pkg"
+	"github.com/slamdev/databaser/pkg/vault"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"strconv"
+	"time"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
 )
 
+const databaseFinalizer = "databaser.slamdev.github.com/finalizer"
+
 // DatabaseReconciler reconciles a Database object
 type DatabaseReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Vault keeps credentials of databases asking for it, nil when not configured.
+	Vault *vault.Client
 }
 
 // +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databases,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databases/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databases/finalizers,verbs=update
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databaseinstances,verbs=get;list;watch
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=clusterdatabaseinstances,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Database object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+// Reconcile provisions the database and its user on the referenced instance, keeps the credentials
+// secret and its copies in sync and reports usage, quota and expiry in the status. Deleted databases
+// are dropped from the instance when cleanup is asked for.
 func (r *DatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = r.Log.WithValues("database", req.NamespacedName)
 
@@ -59,32 +76,142 @@ func (r *DatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		}
 		return ctrl.Result{}, err
 	}
-	if err := controllerutil.SetControllerReference(db, db, r.Scheme); err != nil {
-		return ctrl.Result{}, err
+	if !db.DeletionTimestamp.IsZero() {
+		// the instance is looked up by the finalizer only when there is something to drop on it,
+		// so a missing or broken instance doesn't block the deletion
+		return ctrl.Result{RequeueAfter: time.Second * 60}, r.finalize(ctx, db)
+	}
+	expiresAt, err := expiry(db)
+	if err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	db.Status.ExpiresAt = expiresAt
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		r.Recorder.Event(db, v1.EventTypeNormal, "Expired", "database is expired and deleted")
+		return ctrl.Result{}, r.Client.Delete(ctx, db)
 	}
 
-	instance := &databaserv1alpha1.DatabaseInstance{}
-	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: "", Name: db.Spec.DatabaseInstanceRef.Name}, db); err != nil {
+	instance, err := getInstance(ctx, r.Client, db)
+	if err != nil {
 		if errors.IsNotFound(err) {
 			return ctrl.Result{}, r.updateErrorStatus(ctx, db, "no corresponding database instance found")
 		}
 		return ctrl.Result{}, err
 	}
-	if instance.Status.Phase != "connected" {
+	if instance == nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, "namespace is not allowed to use the database instance")
+	}
+	if instance.GetStatus().Phase != "connected" {
 		return ctrl.Result{}, r.updateErrorStatus(ctx, db, "corresponding database is not initialized")
 	}
 
-	return ctrl.Result{}, nil
+	s, params, err := connectInstance(ctx, r.Client, instance)
+	if err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	defer s.Close()
+
+	if !isProvisioned(db) {
+		total, perNamespace, err := countDatabases(ctx, r.Client, instance, db)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := checkLimits(instance.GetSpec().Limits, db, total, perNamespace); err != nil {
+			return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+		}
+		name, err := resolveDatabaseName(db, instance)
+		if err != nil {
+			return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+		}
+		authDB, err := adminDatabase(ctx, r.Client, instance)
+		if err != nil {
+			return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+		}
+		if err := checkReservedName(instance, []string{params.Username, authDB}, name); err != nil {
+			return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+		}
+		other, err := findNameCollision(ctx, r.Client, db, instance, name)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if other != nil {
+			return ctrl.Result{}, r.updateErrorStatus(ctx, db, fmt.Sprintf("database name %s is already used by %s/%s", name, other.Namespace, other.Name))
+		}
+		// the name is recorded before the finalizer so a provisioned database always knows it
+		db.Status.DatabaseName = name
+		if err := r.Client.Status().Update(ctx, db); err != nil {
+			return ctrl.Result{}, err
+		}
+		exists, err := s.DatabaseExists(ctx, name)
+		if err != nil {
+			return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+		}
+		if exists && db.Spec.Adopt == databaserv1alpha1.AdoptNever {
+			return ctrl.Result{}, r.updateErrorStatus(ctx, db, fmt.Sprintf("database %s already exists and adoption is refused", name))
+		}
+		controllerutil.AddFinalizer(db, databaseFinalizer)
+		if err := r.Client.Update(ctx, db); err != nil {
+			return ctrl.Result{}, err
+		}
+		if exists {
+			r.adoptDatabase(db)
+		}
+	}
+
+	r.checkNameChange(db, instance)
+	if err := checkUserLimit(instance.GetSpec().Limits, db); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+
+	if err := r.ensureDatabase(ctx, db, instance, s); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	if err := r.ensureUser(ctx, db, instance, s, params); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	if err := r.syncSecretTargets(ctx, db, instance); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	if err := r.completeClone(ctx, db, s); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	if err := r.completeAdoption(ctx, db, s); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	if err := r.syncSchemas(ctx, db, s); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	if err := r.syncExtensions(ctx, db, s); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	if err := r.runInitSQL(ctx, db, instance, s); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	if err := r.collectUsage(ctx, db, s); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+	if err := r.checkQuota(ctx, db, s); err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, db, err.Error())
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAfter(db)}, r.updateConnectedStatus(ctx, db)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *DatabaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// status updates are not reconciled, the usage collected on every pass would trigger the next one
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&databaserv1alpha1.Database{}).
+		For(&databaserv1alpha1.Database{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Owns(&v1.Secret{}).
 		Complete(r)
 }
 
 func (r *DatabaseReconciler) updateErrorStatus(ctx context.Context, db *databaserv1alpha1.Database, msg string) error {
+	if !db.DeletionTimestamp.IsZero() {
+		r.Recorder.Event(db, v1.EventTypeWarning, "DeletionBlocked", msg)
+	} else {
+		r.Recorder.Event(db, v1.EventTypeWarning, "Failed", msg)
+	}
 	db.Status.Phase = "failed"
 	db.Status.LastError = msg
 	return r.Client.Status().Update(ctx, db)
@@ -95,3 +222,510 @@ func (r *DatabaseReconciler) updateConnectedStatus(ctx context.Context, db *data
 	db.Status.LastError = ""
 	return r.Client.Status().Update(ctx, db)
 }
+
+func (r *DatabaseReconciler) ensureDatabase(ctx context.Context, db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance, s pkg.Server) error {
+	exists, err := s.DatabaseExists(ctx, dbName(db))
+	if err != nil {
+		return err
+	}
+	if exists {
+		// a clone interrupted before it completed is partial, it is dropped and cloned again
+		c := meta.FindStatusCondition(db.Status.Conditions, databaserv1alpha1.ConditionCloned)
+		if db.Spec.Source == nil || c == nil || c.Reason != "Cloning" {
+			return nil
+		}
+		if err := s.DropDatabase(ctx, dbName(db)); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(db, v1.EventTypeWarning, "CloneRetried", "partial clone %s is dropped to clone it again", dbName(db))
+	}
+	// the init SQL is scheduled before the database is created, so it can't be skipped by a failed status update
+	markInitPending(db)
+	if db.Spec.Source != nil {
+		return r.cloneDatabase(ctx, db, instance, s)
+	}
+	if err := r.Client.Status().Update(ctx, db); err != nil {
+		return err
+	}
+	if err := s.CreateDatabase(ctx, dbName(db)); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(db, v1.EventTypeNormal, "DatabaseCreated", "database %s is created", dbName(db))
+	return nil
+}
+
+// cloneDatabase creates the database as a masked copy of the source living on the same instance
+func (r *DatabaseReconciler) cloneDatabase(ctx context.Context, db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance, s pkg.Server) error {
+	source := &databaserv1alpha1.Database{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: db.Namespace, Name: db.Spec.Source.DatabaseRef.Name}, source); err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("no corresponding source database found")
+		}
+		return err
+	}
+	if !refersTo(source, instance) {
+		return fmt.Errorf("source database %s is on another instance", source.Name)
+	}
+	if !isProvisioned(source) {
+		return fmt.Errorf("source database %s is not provisioned", source.Name)
+	}
+
+	// the clone is recorded as pending first, so a database left by an interrupted clone is not taken as complete
+	meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+		Type:               databaserv1alpha1.ConditionCloned,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: db.Generation,
+		Reason:             "Cloning",
+		Message:            fmt.Sprintf("database is being cloned from %s", source.Name),
+	})
+	if err := r.Client.Status().Update(ctx, db); err != nil {
+		return err
+	}
+
+	var masks []pkg.Mask
+	for _, rule := range db.Spec.Source.Masking {
+		masks = append(masks, pkg.Mask{Table: rule.Table, Column: rule.Column, Value: rule.Value})
+	}
+	if err := s.CloneDatabase(ctx, dbName(source), dbName(db), masks); err != nil {
+		// unmasked data must not stay behind, the clone is retried on the next reconcile
+		if dropErr := s.DropDatabase(ctx, dbName(db)); dropErr != nil {
+			return fmt.Errorf("%v; %w", err, dropErr)
+		}
+		return err
+	}
+	r.Recorder.Eventf(db, v1.EventTypeNormal, "DatabaseCloned", "database %s is cloned from %s", dbName(db), dbName(source))
+	meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+		Type:               databaserv1alpha1.ConditionCloned,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: db.Generation,
+		Reason:             "OwnershipPending",
+		Message:            fmt.Sprintf("database is cloned from %s, objects are not reassigned to its user yet", source.Name),
+	})
+	return r.Client.Status().Update(ctx, db)
+}
+
+// completeClone hands the objects copied from the source over to the user of the database,
+// it is done after the clone since the user doesn't exist yet when the database is cloned.
+func (r *DatabaseReconciler) completeClone(ctx context.Context, db *databaserv1alpha1.Database, s pkg.Server) error {
+	c := meta.FindStatusCondition(db.Status.Conditions, databaserv1alpha1.ConditionCloned)
+	if c == nil || c.Status == metav1.ConditionTrue {
+		return nil
+	}
+	source := &databaserv1alpha1.Database{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: db.Namespace, Name: db.Spec.Source.DatabaseRef.Name}, source); err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("no corresponding source database found")
+		}
+		return err
+	}
+	if err := s.ReassignOwned(ctx, dbName(db), dbName(source), dbName(db)); err != nil {
+		return err
+	}
+	meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+		Type:               databaserv1alpha1.ConditionCloned,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: db.Generation,
+		Reason:             "Cloned",
+		Message:            fmt.Sprintf("database is cloned from %s", source.Name),
+	})
+	return nil
+}
+
+// ensureUser creates the database user and keeps its password in sync with the secret, the secret
+// follows the servicebinding.io spec and is exposed as the binding of the database. A user which
+// existed before is refused, its password is never reset by the operator.
+func (r *DatabaseReconciler) ensureUser(ctx context.Context, db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance, s pkg.Server, params databaserv1alpha1.SqlParams) error {
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: db.Namespace, Name: secretName(db)}}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	password := string(secret.Data["password"])
+	if password == "" && db.Spec.Vault != nil {
+		var err error
+		if password, err = readVaultPassword(ctx, r.Vault, db); err != nil {
+			return err
+		}
+	}
+
+	exists, err := s.UserExists(ctx, dbName(db))
+	if err != nil {
+		return err
+	}
+	if exists && db.Status.User != dbName(db) {
+		// a user the operator hasn't created keeps its password, the known one is imported instead
+		if password, err = r.adoptUser(ctx, db, s, password); err != nil {
+			return err
+		}
+	} else {
+		generated := password == ""
+		if generated {
+			if password, err = pkg.GeneratePassword(); err != nil {
+				return err
+			}
+		}
+		if !exists {
+			// the user is recorded before it is created, so a failed status update can't leave it untracked
+			db.Status.User = dbName(db)
+			if err := r.Client.Status().Update(ctx, db); err != nil {
+				return err
+			}
+			if err := s.CreateUser(ctx, dbName(db), password); err != nil {
+				return err
+			}
+			r.Recorder.Eventf(db, v1.EventTypeNormal, "UserCreated", "user %s is created", dbName(db))
+		} else if generated {
+			if err := s.SetPassword(ctx, dbName(db), password); err != nil {
+				return err
+			}
+			r.Recorder.Eventf(db, v1.EventTypeNormal, "PasswordRotated", "password of user %s is rotated", dbName(db))
+		}
+	}
+	// the grants are restored when the quota is released
+	if !db.Status.QuotaEnforced {
+		if err := s.GrantAll(ctx, dbName(db), dbName(db)); err != nil {
+			return err
+		}
+	}
+	if !exists {
+		r.Recorder.Eventf(db, v1.EventTypeNormal, "GrantsChanged", "all privileges on %s are granted to %s", dbName(db), dbName(db))
+	}
+
+	data := map[string][]byte{
+		"type":     []byte(bindingType(instance)),
+		"provider": []byte("databaser"),
+		"host":     []byte(params.Host),
+		"port":     []byte(strconv.Itoa(params.Port)),
+		"database": []byte(dbName(db)),
+		"username": []byte(dbName(db)),
+		"password": []byte(password),
+	}
+	if db.Spec.SecretTemplate != nil {
+		rendered, err := renderSecretTemplate(ctx, r.Client, db, secretTemplateData{
+			Host:     params.Host,
+			Port:     params.Port,
+			Database: dbName(db),
+			Username: dbName(db),
+			Password: password,
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range rendered {
+			data[k] = v
+		}
+	}
+
+	if db.Spec.Vault != nil {
+		if err := r.writeVaultCredentials(ctx, db, data); err != nil {
+			return err
+		}
+		if db.Spec.Vault.SkipSecret {
+			db.Status.Binding = nil
+			if secret.UID != "" && metav1.IsControlledBy(secret, db) {
+				return r.Client.Delete(ctx, secret)
+			}
+			return nil
+		}
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Data = data
+		return controllerutil.SetControllerReference(db, secret, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+	db.Status.Binding = &databaserv1alpha1.ServiceBindingReference{Name: secret.Name}
+	return nil
+}
+
+// adoptUser returns the password of an existing user the operator hasn't created, it is read from
+// the referenced secret or is the known one of the database and should log the user in
+func (r *DatabaseReconciler) adoptUser(ctx context.Context, db *databaserv1alpha1.Database, s pkg.Server, known string) (string, error) {
+	if db.Spec.Adopt == databaserv1alpha1.AdoptNever {
+		return "", fmt.Errorf("user %s already exists and adoption is refused", dbName(db))
+	}
+	password := known
+	if ref := db.Spec.PasswordSecretKeyRef; ref != nil {
+		secret := &v1.Secret{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: db.Namespace, Name: ref.Name}, secret); err != nil {
+			return "", fmt.Errorf("failed to get password secret %s; %w", ref.Name, err)
+		}
+		password = string(secret.Data[ref.Key])
+		if password == "" {
+			return "", fmt.Errorf("key %s is not found in secret %s", ref.Key, ref.Name)
+		}
+	}
+	if password == "" {
+		return "", fmt.Errorf("user %s already exists and is not created by the operator, set passwordSecretKeyRef to adopt it", dbName(db))
+	}
+	conn, err := s.ConnectAs(ctx, dbName(db), dbName(db), password)
+	if err != nil {
+		return "", fmt.Errorf("failed to log in as existing user %s with the imported password; %w", dbName(db), err)
+	}
+	conn.Close()
+	if password != known {
+		r.Recorder.Eventf(db, v1.EventTypeNormal, "UserAdopted", "existing user %s is adopted with the password of secret %s", dbName(db), db.Spec.PasswordSecretKeyRef.Name)
+	}
+	return password, nil
+}
+
+// bindingType is the well known servicebinding.io type of the engine
+func bindingType(instance databaserv1alpha1.GenericDatabaseInstance) string {
+	if instanceEngine(instance) == "clickhouse" {
+		return "clickhouse"
+	}
+	return "postgresql"
+}
+
+// checkNameChange reports a name asked for by the spec or the template that differs from the provisioned one
+func (r *DatabaseReconciler) checkNameChange(db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance) {
+	// databases provisioned before the name was recorded keep the name of the object whatever the template is
+	name, err := resolveDatabaseName(db, instance)
+	if err != nil || name == dbName(db) || (db.Status.DatabaseName == "" && db.Spec.DatabaseName == "") {
+		// removing from empty conditions panics
+		if meta.FindStatusCondition(db.Status.Conditions, databaserv1alpha1.ConditionNameChanged) != nil {
+			meta.RemoveStatusCondition(&db.Status.Conditions, databaserv1alpha1.ConditionNameChanged)
+		}
+		return
+	}
+	msg := fmt.Sprintf("name %s differs from the provisioned name %s, the database is not renamed", name, dbName(db))
+	if !meta.IsStatusConditionTrue(db.Status.Conditions, databaserv1alpha1.ConditionNameChanged) {
+		r.Recorder.Event(db, v1.EventTypeWarning, "NameChanged", msg)
+	}
+	meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+		Type:               databaserv1alpha1.ConditionNameChanged,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: db.Generation,
+		Reason:             "NameChanged",
+		Message:            msg,
+	})
+}
+
+// adoptDatabase records that the database existed before the object was provisioned
+func (r *DatabaseReconciler) adoptDatabase(db *databaserv1alpha1.Database) {
+	r.Recorder.Eventf(db, v1.EventTypeNormal, "DatabaseAdopted", "existing database %s is adopted", dbName(db))
+	if db.Spec.ResetOwner {
+		meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+			Type:               databaserv1alpha1.ConditionAdopted,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: db.Generation,
+			Reason:             "OwnershipPending",
+			Message:            "database is adopted, its owner is not reset to the user yet",
+		})
+		return
+	}
+	meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+		Type:               databaserv1alpha1.ConditionAdopted,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: db.Generation,
+		Reason:             "Adopted",
+		Message:            "database existed before and is adopted",
+	})
+}
+
+// completeAdoption makes the user the owner of an adopted database once the user exists
+func (r *DatabaseReconciler) completeAdoption(ctx context.Context, db *databaserv1alpha1.Database, s pkg.Server) error {
+	c := meta.FindStatusCondition(db.Status.Conditions, databaserv1alpha1.ConditionAdopted)
+	if c == nil || c.Status == metav1.ConditionTrue {
+		return nil
+	}
+	if err := s.SetOwner(ctx, dbName(db), dbName(db)); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(db, v1.EventTypeNormal, "OwnerChanged", "owner of database %s is reset to %s", dbName(db), dbName(db))
+	meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+		Type:               databaserv1alpha1.ConditionAdopted,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: db.Generation,
+		Reason:             "Adopted",
+		Message:            "database existed before and is adopted with its owner reset to the user",
+	})
+	return nil
+}
+
+func (r *DatabaseReconciler) collectUsage(ctx context.Context, db *databaserv1alpha1.Database, s pkg.Server) error {
+	stats, err := s.DatabaseStats(ctx, dbName(db))
+	if err != nil {
+		return err
+	}
+	db.Status.Usage = &databaserv1alpha1.DatabaseUsage{
+		SizeBytes:   stats.SizeBytes,
+		Tables:      stats.Tables,
+		Connections: stats.Connections,
+		CollectedAt: metav1.Now(),
+	}
+	databaseSizeBytes.WithLabelValues(db.Namespace, db.Name).Set(float64(stats.SizeBytes))
+	databaseTables.WithLabelValues(db.Namespace, db.Name).Set(float64(stats.Tables))
+	databaseConnections.WithLabelValues(db.Namespace, db.Name).Set(float64(stats.Connections))
+	return nil
+}
+
+// checkQuota reports quota violations and, when enforced, keeps the user read only until the usage drops
+func (r *DatabaseReconciler) checkQuota(ctx context.Context, db *databaserv1alpha1.Database, s pkg.Server) error {
+	quota := db.Spec.Quota
+	if quota == nil {
+		// removing from empty conditions panics
+		if meta.FindStatusCondition(db.Status.Conditions, databaserv1alpha1.ConditionQuotaExceeded) != nil {
+			meta.RemoveStatusCondition(&db.Status.Conditions, databaserv1alpha1.ConditionQuotaExceeded)
+		}
+		return r.setQuotaEnforced(ctx, db, s, false)
+	}
+
+	size := db.Status.Usage.SizeBytes
+	limit := quota.MaxBytes.Value()
+	threshold := quota.WarningThreshold
+	if threshold == 0 {
+		threshold = 80
+	}
+	exceeded := size > limit
+	if exceeded {
+		msg := fmt.Sprintf("database size %d bytes exceeds quota of %d bytes", size, limit)
+		if !meta.IsStatusConditionTrue(db.Status.Conditions, databaserv1alpha1.ConditionQuotaExceeded) {
+			r.Recorder.Event(db, v1.EventTypeWarning, "QuotaExceeded", msg)
+		}
+		meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+			Type:               databaserv1alpha1.ConditionQuotaExceeded,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: db.Generation,
+			Reason:             "QuotaExceeded",
+			Message:            msg,
+		})
+	} else if size*100 > limit*int64(threshold) {
+		msg := fmt.Sprintf("database size %d bytes is above %d%% of quota of %d bytes", size, threshold, limit)
+		if c := meta.FindStatusCondition(db.Status.Conditions, databaserv1alpha1.ConditionQuotaExceeded); c == nil || c.Reason != "QuotaWarning" {
+			r.Recorder.Event(db, v1.EventTypeWarning, "QuotaWarning", msg)
+		}
+		meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+			Type:               databaserv1alpha1.ConditionQuotaExceeded,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: db.Generation,
+			Reason:             "QuotaWarning",
+			Message:            msg,
+		})
+	} else {
+		meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+			Type:               databaserv1alpha1.ConditionQuotaExceeded,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: db.Generation,
+			Reason:             "WithinQuota",
+			Message:            fmt.Sprintf("database size %d bytes is within quota of %d bytes", size, limit),
+		})
+	}
+	return r.setQuotaEnforced(ctx, db, s, exceeded && quota.Enforce)
+}
+
+// setQuotaEnforced revokes the write privileges while the quota is enforced, it is applied on every
+// reconcile since tables created meanwhile are writable, and restores them once the quota is released
+func (r *DatabaseReconciler) setQuotaEnforced(ctx context.Context, db *databaserv1alpha1.Database, s pkg.Server, enforced bool) error {
+	if !enforced && !db.Status.QuotaEnforced {
+		return nil
+	}
+	if err := s.SetReadOnly(ctx, dbName(db), dbName(db), enforced); err != nil {
+		return err
+	}
+	if enforced == db.Status.QuotaEnforced {
+		return nil
+	}
+	if enforced {
+		// open sessions keep writing in their transactions until they reconnect
+		if err := s.TerminateSessions(ctx, dbName(db)); err != nil {
+			return err
+		}
+		r.Recorder.Event(db, v1.EventTypeWarning, "QuotaEnforced", "write access of the database user is revoked")
+	} else {
+		r.Recorder.Event(db, v1.EventTypeNormal, "QuotaReleased", "write access of the database user is restored")
+	}
+	db.Status.QuotaEnforced = enforced
+	return nil
+}
+
+func (r *DatabaseReconciler) finalize(ctx context.Context, db *databaserv1alpha1.Database) error {
+	if !controllerutil.ContainsFinalizer(db, databaseFinalizer) {
+		return nil
+	}
+	if db.Spec.Cleanup {
+		if err := r.cleanup(ctx, db); err != nil {
+			return r.updateErrorStatus(ctx, db, err.Error())
+		}
+	}
+	if err := r.deleteSecretTargets(ctx, db, nil); err != nil {
+		return r.updateErrorStatus(ctx, db, err.Error())
+	}
+	databaseSizeBytes.DeleteLabelValues(db.Namespace, db.Name)
+	databaseTables.DeleteLabelValues(db.Namespace, db.Name)
+	databaseConnections.DeleteLabelValues(db.Namespace, db.Name)
+	controllerutil.RemoveFinalizer(db, databaseFinalizer)
+	return r.Client.Update(ctx, db)
+}
+
+// cleanup drops the database and the user created for it. Nothing is dropped when the instance is
+// gone or no longer allowed, a reachable instance is required otherwise.
+func (r *DatabaseReconciler) cleanup(ctx context.Context, db *databaserv1alpha1.Database) error {
+	instance, err := getInstance(ctx, r.Client, db)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if instance == nil {
+		r.Recorder.Eventf(db, v1.EventTypeWarning, "CleanupSkipped", "instance is not available, database %s is not dropped", dbName(db))
+	} else {
+		s, _, err := connectInstance(ctx, r.Client, instance)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		if err := s.DropDatabase(ctx, dbName(db)); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(db, v1.EventTypeNormal, "DatabaseDropped", "database %s is dropped", dbName(db))
+		if db.Status.User == dbName(db) {
+			if err := s.DropUser(ctx, dbName(db)); err != nil {
+				return err
+			}
+			r.Recorder.Eventf(db, v1.EventTypeNormal, "UserDropped", "user %s is dropped", dbName(db))
+		}
+	}
+	if db.Spec.Vault != nil {
+		return r.deleteVaultCredentials(ctx, db)
+	}
+	return nil
+}
+
+// expiry returns when the database is deleted or nil when it doesn't expire
+func expiry(db *databaserv1alpha1.Database) (*metav1.Time, error) {
+	var t time.Time
+	if db.Spec.ExpiresAt != nil {
+		t = db.Spec.ExpiresAt.Time
+	} else if db.Spec.TTL != nil {
+		t = db.CreationTimestamp.Add(db.Spec.TTL.Duration)
+	} else {
+		return nil, nil
+	}
+	if val, ok := db.Annotations[databaserv1alpha1.ExtendTTLAnnotation]; ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s annotation; %w", databaserv1alpha1.ExtendTTLAnnotation, err)
+		}
+		t = t.Add(d)
+	}
+	expiresAt := metav1.NewTime(t)
+	return &expiresAt, nil
+}
+
+// requeueAfter checks the database every minute or at its expiry when it comes sooner
+func requeueAfter(db *databaserv1alpha1.Database) time.Duration {
+	after := time.Second * 60
+	if db.Status.ExpiresAt != nil {
+		if d := time.Until(db.Status.ExpiresAt.Time); d < after {
+			after = d
+		}
+	}
+	return after
+}
+
+func secretName(db *databaserv1alpha1.Database) string {
+	if db.Spec.SecretName != "" {
+		return db.Spec.SecretName
+	}
+	return db.Name
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+	"github.com/slamdev/databaser/pkg"
+)
+
+// fakeServer records the calls changing the access of the user, other methods are not implemented
+type fakeServer struct {
+	pkg.Server
+	readOnly   []bool
+	terminated int
+}
+
+func (s *fakeServer) SetReadOnly(_ context.Context, _ string, _ string, readOnly bool) error {
+	s.readOnly = append(s.readOnly, readOnly)
+	return nil
+}
+
+func (s *fakeServer) TerminateSessions(_ context.Context, _ string) error {
+	s.terminated++
+	return nil
+}
+
+func TestCheckQuota(t *testing.T) {
+	for name, tc := range map[string]struct {
+		quota      *databaserv1alpha1.DatabaseQuota
+		size       int64
+		enforced   bool
+		wantReason string
+		wantCalls  []bool
+		wantKilled int
+		wantStatus bool
+	}{
+		"no quota": {
+			size: 100,
+		},
+		"within quota": {
+			quota:      &databaserv1alpha1.DatabaseQuota{MaxBytes: resource.MustParse("1000"), WarningThreshold: 80},
+			size:       100,
+			wantReason: "WithinQuota",
+		},
+		"warning": {
+			quota:      &databaserv1alpha1.DatabaseQuota{MaxBytes: resource.MustParse("1000"), WarningThreshold: 80},
+			size:       900,
+			wantReason: "QuotaWarning",
+		},
+		"exceeded without enforce": {
+			quota:      &databaserv1alpha1.DatabaseQuota{MaxBytes: resource.MustParse("1000")},
+			size:       1001,
+			wantReason: "QuotaExceeded",
+		},
+		"exceeded with enforce": {
+			quota:      &databaserv1alpha1.DatabaseQuota{MaxBytes: resource.MustParse("1000"), Enforce: true},
+			size:       1001,
+			wantReason: "QuotaExceeded",
+			wantCalls:  []bool{true},
+			wantKilled: 1,
+			wantStatus: true,
+		},
+		"still exceeded": {
+			quota:      &databaserv1alpha1.DatabaseQuota{MaxBytes: resource.MustParse("1000"), Enforce: true},
+			size:       1001,
+			enforced:   true,
+			wantReason: "QuotaExceeded",
+			wantCalls:  []bool{true},
+			wantStatus: true,
+		},
+		"released": {
+			quota:      &databaserv1alpha1.DatabaseQuota{MaxBytes: resource.MustParse("1000"), Enforce: true},
+			size:       10,
+			enforced:   true,
+			wantReason: "WithinQuota",
+			wantCalls:  []bool{false},
+		},
+		"quota removed": {
+			size:      1001,
+			enforced:  true,
+			wantCalls: []bool{false},
+		},
+	} {
+		db := &databaserv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "default"},
+			Spec:       databaserv1alpha1.DatabaseSpec{Quota: tc.quota},
+			Status: databaserv1alpha1.DatabaseStatus{
+				Usage:         &databaserv1alpha1.DatabaseUsage{SizeBytes: tc.size},
+				QuotaEnforced: tc.enforced,
+			},
+		}
+		s := &fakeServer{}
+		r := &DatabaseReconciler{Recorder: record.NewFakeRecorder(10)}
+		if err := r.checkQuota(context.Background(), db, s); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		reason := ""
+		if c := meta.FindStatusCondition(db.Status.Conditions, databaserv1alpha1.ConditionQuotaExceeded); c != nil {
+			reason = c.Reason
+		}
+		if reason != tc.wantReason {
+			t.Errorf("%s: got reason %q, want %q", name, reason, tc.wantReason)
+		}
+		if !reflect.DeepEqual(s.readOnly, tc.wantCalls) {
+			t.Errorf("%s: got read only calls %v, want %v", name, s.readOnly, tc.wantCalls)
+		}
+		if s.terminated != tc.wantKilled {
+			t.Errorf("%s: got %d terminations, want %d", name, s.terminated, tc.wantKilled)
+		}
+		if db.Status.QuotaEnforced != tc.wantStatus {
+			t.Errorf("%s: got enforced %v, want %v", name, db.Status.QuotaEnforced, tc.wantStatus)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	created := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := metav1.NewTime(created.Add(time.Hour * 5))
+	for name, tc := range map[string]struct {
+		spec    databaserv1alpha1.DatabaseSpec
+		extend  string
+		want    *time.Time
+		wantErr bool
+	}{
+		"no expiry": {},
+		"ttl": {
+			spec: databaserv1alpha1.DatabaseSpec{TTL: &metav1.Duration{Duration: time.Hour}},
+			want: timePtr(created.Add(time.Hour)),
+		},
+		"expires at": {
+			spec: databaserv1alpha1.DatabaseSpec{ExpiresAt: &at},
+			want: timePtr(at.Time),
+		},
+		"expires at takes precedence": {
+			spec: databaserv1alpha1.DatabaseSpec{ExpiresAt: &at, TTL: &metav1.Duration{Duration: time.Hour}},
+			want: timePtr(at.Time),
+		},
+		"extended": {
+			spec:   databaserv1alpha1.DatabaseSpec{TTL: &metav1.Duration{Duration: time.Hour}},
+			extend: "30m",
+			want:   timePtr(created.Add(time.Minute * 90)),
+		},
+		"extension without expiry": {
+			extend: "30m",
+		},
+		"invalid extension": {
+			spec:    databaserv1alpha1.DatabaseSpec{TTL: &metav1.Duration{Duration: time.Hour}},
+			extend:  "a day",
+			wantErr: true,
+		},
+	} {
+		db := &databaserv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", CreationTimestamp: metav1.NewTime(created)},
+			Spec:       tc.spec,
+		}
+		if tc.extend != "" {
+			db.Annotations = map[string]string{databaserv1alpha1.ExtendTTLAnnotation: tc.extend}
+		}
+		got, err := expiry(db)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", name, err, tc.wantErr)
+			continue
+		}
+		switch {
+		case got == nil && tc.want == nil:
+		case got == nil || tc.want == nil || !got.Time.Equal(*tc.want):
+			t.Errorf("%s: got %v, want %v", name, got, tc.want)
+		}
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"net/http"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// +kubebuilder:webhook:path=/validate-databaser-slamdev-github-com-v1alpha1-database,mutating=false,failurePolicy=fail,sideEffects=None,groups=databaser.slamdev.github.com,resources=databases,verbs=create;update,versions=v1alpha1,name=vdatabase.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// DatabaseValidator rejects databases that don't fit into the limits of the instance
+type DatabaseValidator struct {
+	Client  client.Client
+	decoder *admission.Decoder
+}
+
+// SetupWebhookWithManager registers the webhook in the Manager.
+func (v *DatabaseValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register("/validate-databaser-slamdev-github-com-v1alpha1-database", &webhook.Admission{Handler: v})
+	return nil
+}
+
+func (v *DatabaseValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	db := &databaserv1alpha1.Database{}
+	if err := v.decoder.Decode(req, db); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if !db.DeletionTimestamp.IsZero() {
+		return admission.Allowed("")
+	}
+
+	instance, err := getInstance(ctx, v.Client, db)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// the instance may be created later, the controller reports it in the status
+			return admission.Allowed("")
+		}
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if instance == nil {
+		return admission.Denied("namespace is not allowed to use the database instance")
+	}
+
+	// an existing database keeps its place, updates are only checked for the users they grant access to
+	if req.Operation == admissionv1.Update {
+		if err := checkUserLimit(instance.GetSpec().Limits, db); err != nil {
+			return admission.Denied(err.Error())
+		}
+		return admission.Allowed("")
+	}
+	total, perNamespace, err := countDatabases(ctx, v.Client, instance, db)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if err := checkLimits(instance.GetSpec().Limits, db, total, perNamespace); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+func (v *DatabaseValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
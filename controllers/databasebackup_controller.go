@@ -0,0 +1,209 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+const backupFinalizer = "databaser.slamdev.github.com/backup"
+
+// deleteArtifactRetryDelay is the time a failed job deleting a backup is kept before it is started again
+const deleteArtifactRetryDelay = time.Minute
+
+// DatabaseBackupReconciler reconciles a DatabaseBackup object
+type DatabaseBackupReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasebackups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasebackups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasebackups/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile runs a job dumping the database to the object storage and reports its outcome in the status.
+func (r *DatabaseBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = r.Log.WithValues("databasebackup", req.NamespacedName)
+
+	backup := &databaserv1alpha1.DatabaseBackup{}
+	if err := r.Client.Get(ctx, req.NamespacedName, backup); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if !backup.DeletionTimestamp.IsZero() {
+		return r.deleteArtifact(ctx, backup)
+	}
+	if backup.Spec.DeletionPolicy == databaserv1alpha1.DeletionPolicyDelete && !controllerutil.ContainsFinalizer(backup, backupFinalizer) {
+		controllerutil.AddFinalizer(backup, backupFinalizer)
+		if err := r.Client.Update(ctx, backup); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if backup.Status.Phase == databaserv1alpha1.PhaseCompleted || backup.Status.Phase == databaserv1alpha1.PhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	job := &batchv1.Job{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: backup.Namespace, Name: backupJobName(backup)}, job); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, r.startJob(ctx, backup)
+	}
+
+	finished, result := jobFinished(job)
+	if !finished {
+		return ctrl.Result{}, nil
+	}
+	if result == batchv1.JobFailed {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, backup, fmt.Sprintf("backup job %s failed", job.Name))
+	}
+	out, err := getJobResult(ctx, r.Client, job, "upload")
+	if err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, backup, err.Error())
+	}
+	now := metav1.Now()
+	backup.Status.Phase = databaserv1alpha1.PhaseCompleted
+	backup.Status.LastError = ""
+	backup.Status.SizeBytes = out.SizeBytes
+	backup.Status.Checksum = out.Checksum
+	backup.Status.CompletedAt = &now
+	r.Recorder.Eventf(backup, v1.EventTypeNormal, "BackupCompleted", "backup is uploaded to %s", backup.Status.Location)
+	return ctrl.Result{}, r.Client.Status().Update(ctx, backup)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DatabaseBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databaserv1alpha1.DatabaseBackup{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
+
+func (r *DatabaseBackupReconciler) startJob(ctx context.Context, backup *databaserv1alpha1.DatabaseBackup) error {
+	db := &databaserv1alpha1.Database{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: backup.Namespace, Name: backup.Spec.DatabaseRef.Name}, db); err != nil {
+		if errors.IsNotFound(err) {
+			return r.updateErrorStatus(ctx, backup, "no corresponding database found")
+		}
+		return err
+	}
+	instance, err := getInstance(ctx, r.Client, db)
+	if err != nil {
+		return r.updateErrorStatus(ctx, backup, err.Error())
+	}
+	if instance == nil {
+		return r.updateErrorStatus(ctx, backup, "namespace is not allowed to use the database instance")
+	}
+	if db.Spec.Vault != nil && db.Spec.Vault.SkipSecret {
+		return r.updateErrorStatus(ctx, backup, "backup job requires the credentials secret of the database")
+	}
+	if usesSSHTunnel(instance) {
+		return r.updateErrorStatus(ctx, backup, "backup job can't reach an instance behind an ssh tunnel")
+	}
+
+	engine := instanceEngine(instance)
+	job := newBackupJob(backup, db, engine)
+	if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+		return err
+	}
+
+	// the location is recorded before the upload can start, so the artifact is deleted with the backup
+	// even when the job completes before the status is updated
+	now := metav1.Now()
+	backup.Status.Phase = databaserv1alpha1.PhaseRunning
+	backup.Status.Location = backupURL(backup.Spec.Storage.S3, backup, engine)
+	backup.Status.StartedAt = &now
+	if err := r.Client.Status().Update(ctx, backup); err != nil {
+		return err
+	}
+	if err := r.Client.Create(ctx, job); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(backup, v1.EventTypeNormal, "BackupStarted", "backup job %s is created", job.Name)
+	return nil
+}
+
+// deleteArtifact removes the uploaded backup from the storage before the object is gone, a failed
+// job is replaced after a delay until the backup is deleted
+func (r *DatabaseBackupReconciler) deleteArtifact(ctx context.Context, backup *databaserv1alpha1.DatabaseBackup) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(backup, backupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	if backup.Status.Location != "" {
+		job := &batchv1.Job{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: backup.Namespace, Name: deleteArtifactJobName(backup)}, job); err != nil {
+			if !errors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			job = newDeleteArtifactJob(backup)
+			if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, r.Client.Create(ctx, job)
+		}
+		if !job.DeletionTimestamp.IsZero() {
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		finished, result := jobFinished(job)
+		if !finished {
+			return ctrl.Result{}, nil
+		}
+		if result == batchv1.JobFailed {
+			if wait := deleteArtifactRetryDelay - time.Since(jobFinishedAt(job)); wait > 0 {
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+			r.Recorder.Eventf(backup, v1.EventTypeWarning, "DeletionRetried", "failed to delete %s, job %s is started again", backup.Status.Location, job.Name)
+			if err := r.Client.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		r.Recorder.Eventf(backup, v1.EventTypeNormal, "BackupDeleted", "backup is deleted from %s", backup.Status.Location)
+	}
+	controllerutil.RemoveFinalizer(backup, backupFinalizer)
+	return ctrl.Result{}, r.Client.Update(ctx, backup)
+}
+
+func (r *DatabaseBackupReconciler) updateErrorStatus(ctx context.Context, backup *databaserv1alpha1.DatabaseBackup, msg string) error {
+	r.Recorder.Event(backup, v1.EventTypeWarning, "BackupFailed", msg)
+	backup.Status.Phase = databaserv1alpha1.PhaseFailed
+	backup.Status.LastError = msg
+	return r.Client.Status().Update(ctx, backup)
+}
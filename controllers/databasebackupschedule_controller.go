@@ -0,0 +1,190 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/slamdev/databaser/pkg/retention"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// scheduleLabel marks backups created by a DatabaseBackupSchedule
+const scheduleLabel = "databaser.slamdev.github.com/schedule"
+
+// DatabaseBackupScheduleReconciler reconciles a DatabaseBackupSchedule object
+type DatabaseBackupScheduleReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasebackupschedules,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasebackupschedules/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasebackupschedules/finalizers,verbs=update
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasebackups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile creates backups when they are due and prunes the ones the retention rules don't keep.
+func (r *DatabaseBackupScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = r.Log.WithValues("databasebackupschedule", req.NamespacedName)
+
+	schedule := &databaserv1alpha1.DatabaseBackupSchedule{}
+	if err := r.Client.Get(ctx, req.NamespacedName, schedule); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	sched, err := cron.ParseStandard(schedule.Spec.Schedule)
+	if err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, schedule, fmt.Sprintf("invalid schedule; %s", err))
+	}
+
+	if err := r.prune(ctx, schedule); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	last := schedule.CreationTimestamp.Time
+	if schedule.Status.LastScheduleTime != nil {
+		last = schedule.Status.LastScheduleTime.Time
+	}
+	next := sched.Next(last)
+	if next.After(now) || schedule.Spec.Suspend {
+		return ctrl.Result{RequeueAfter: next.Sub(now)}, r.updateScheduledStatus(ctx, schedule)
+	}
+	// missed runs are collapsed into the most recent one
+	for n := sched.Next(next); !n.After(now); n = sched.Next(n) {
+		next = n
+	}
+
+	backup := &databaserv1alpha1.DatabaseBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: schedule.Namespace,
+			Name:      fmt.Sprintf("%s-%d", schedule.Name, next.Unix()),
+			Labels:    map[string]string{scheduleLabel: schedule.Name},
+		},
+		Spec: schedule.Spec.BackupTemplate,
+	}
+	backup.Spec.DeletionPolicy = databaserv1alpha1.DeletionPolicyDelete
+	if err := r.Client.Create(ctx, backup); err != nil && !errors.IsAlreadyExists(err) {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, schedule, err.Error())
+	}
+	r.Recorder.Eventf(schedule, v1.EventTypeNormal, "BackupScheduled", "backup %s is created", backup.Name)
+
+	scheduled := metav1.NewTime(next)
+	schedule.Status.LastScheduleTime = &scheduled
+	schedule.Status.LastBackup = backup.Name
+	return ctrl.Result{RequeueAfter: sched.Next(next).Sub(now)}, r.updateScheduledStatus(ctx, schedule)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DatabaseBackupScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databaserv1alpha1.DatabaseBackupSchedule{}).
+		Watches(&source.Kind{Type: &databaserv1alpha1.DatabaseBackup{}}, handler.EnqueueRequestsFromMapFunc(scheduleRequest)).
+		Complete(r)
+}
+
+// scheduleRequest maps a scheduled backup to the reconcile request of its schedule
+func scheduleRequest(o client.Object) []reconcile.Request {
+	name, ok := o.GetLabels()[scheduleLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: o.GetNamespace(), Name: name}}}
+}
+
+// prune deletes finished backups of the schedule that are not kept by the retention rules,
+// failed backups are kept only while they are newer than the last completed one
+func (r *DatabaseBackupScheduleReconciler) prune(ctx context.Context, schedule *databaserv1alpha1.DatabaseBackupSchedule) error {
+	list := &databaserv1alpha1.DatabaseBackupList{}
+	if err := r.Client.List(ctx, list, client.InNamespace(schedule.Namespace), client.MatchingLabels{scheduleLabel: schedule.Name}); err != nil {
+		return err
+	}
+
+	var completed []databaserv1alpha1.DatabaseBackup
+	var created []time.Time
+	var lastCompleted time.Time
+	for _, b := range list.Items {
+		if b.Status.Phase == databaserv1alpha1.PhaseCompleted && b.DeletionTimestamp.IsZero() {
+			completed = append(completed, b)
+			created = append(created, b.CreationTimestamp.Time)
+			if b.CreationTimestamp.Time.After(lastCompleted) {
+				lastCompleted = b.CreationTimestamp.Time
+			}
+		}
+	}
+
+	keep := retention.Select(created, time.Now(), retention.Policy{
+		KeepLast:  schedule.Spec.Retention.KeepLast,
+		KeepDaily: schedule.Spec.Retention.KeepDaily,
+	})
+	var expired []databaserv1alpha1.DatabaseBackup
+	for i, b := range completed {
+		if !keep[i] {
+			expired = append(expired, b)
+		}
+	}
+	for _, b := range list.Items {
+		if b.Status.Phase == databaserv1alpha1.PhaseFailed && b.DeletionTimestamp.IsZero() && b.CreationTimestamp.Time.Before(lastCompleted) {
+			expired = append(expired, b)
+		}
+	}
+
+	for i := range expired {
+		if err := r.Client.Delete(ctx, &expired[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(schedule, v1.EventTypeNormal, "BackupPruned", "backup %s is deleted", expired[i].Name)
+	}
+	return nil
+}
+
+func (r *DatabaseBackupScheduleReconciler) updateErrorStatus(ctx context.Context, schedule *databaserv1alpha1.DatabaseBackupSchedule, msg string) error {
+	r.Recorder.Event(schedule, v1.EventTypeWarning, "Failed", msg)
+	schedule.Status.Phase = databaserv1alpha1.PhaseFailed
+	schedule.Status.LastError = msg
+	return r.Client.Status().Update(ctx, schedule)
+}
+
+func (r *DatabaseBackupScheduleReconciler) updateScheduledStatus(ctx context.Context, schedule *databaserv1alpha1.DatabaseBackupSchedule) error {
+	schedule.Status.Phase = "scheduled"
+	if schedule.Spec.Suspend {
+		schedule.Status.Phase = "suspended"
+	}
+	schedule.Status.LastError = ""
+	return r.Client.Status().Update(ctx, schedule)
+}
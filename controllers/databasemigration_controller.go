@@ -0,0 +1,276 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/slamdev/databaser/pkg/migrate"
+	"github.com/slamdev/databaser/pkg/vault"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// DatabaseMigrationReconciler reconciles a DatabaseMigration object
+type DatabaseMigrationReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Vault    *vault.Client
+	// Image of the operator running the migrate command in the jobs of image sources.
+	OperatorImage string
+}
+
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasemigrations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasemigrations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasemigrations/finalizers,verbs=update
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databases,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile applies the pending migrations of the source to the database, in-process for a ConfigMap and with a
+// job for an image. A failed migration blocks the following ones until the spec or the ConfigMap changes.
+func (r *DatabaseMigrationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = r.Log.WithValues("databasemigration", req.NamespacedName)
+
+	migration := &databaserv1alpha1.DatabaseMigration{}
+	if err := r.Client.Get(ctx, req.NamespacedName, migration); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	spec := migration.Spec.Source
+	if (spec.ConfigMap == nil) == (spec.Image == nil) {
+		return ctrl.Result{}, r.updateFailedStatus(ctx, migration, "", "exactly one of configMap or image source should be defined")
+	}
+
+	var files map[string]string
+	checksum := ""
+	if spec.ConfigMap != nil {
+		cm := &v1.ConfigMap{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: migration.Namespace, Name: spec.ConfigMap.Name}, cm); err != nil {
+			if errors.IsNotFound(err) {
+				return r.waitFor(ctx, migration, fmt.Sprintf("configmap %s is not found", spec.ConfigMap.Name))
+			}
+			return ctrl.Result{}, err
+		}
+		files = cm.Data
+		checksum = filesChecksum(files)
+	}
+	status := migration.Status
+	if status.ObservedGeneration == migration.Generation && status.SourceChecksum == checksum &&
+		(status.Phase == databaserv1alpha1.PhaseCompleted || status.Phase == databaserv1alpha1.PhaseFailed) {
+		return ctrl.Result{}, nil
+	}
+
+	db := &databaserv1alpha1.Database{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: migration.Namespace, Name: migration.Spec.DatabaseRef.Name}, db); err != nil {
+		if errors.IsNotFound(err) {
+			return r.waitFor(ctx, migration, "no corresponding database found")
+		}
+		return ctrl.Result{}, err
+	}
+	// the user of the database applies the migrations, it exists once the database is connected
+	if db.Status.Phase != "connected" {
+		return r.waitFor(ctx, migration, fmt.Sprintf("database %s is not connected", db.Name))
+	}
+	instance, err := getInstance(ctx, r.Client, db)
+	if err != nil {
+		return r.waitFor(ctx, migration, err.Error())
+	}
+	if instance == nil {
+		return r.waitFor(ctx, migration, "namespace is not allowed to use the database instance")
+	}
+
+	if spec.Image != nil {
+		return r.runJob(ctx, migration, db, instance)
+	}
+	return r.apply(ctx, migration, db, instance, files, checksum)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DatabaseMigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databaserv1alpha1.DatabaseMigration{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &v1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.configMapRequests)).
+		Complete(r)
+}
+
+// configMapRequests maps a configmap to the migrations it is the source of
+func (r *DatabaseMigrationReconciler) configMapRequests(o client.Object) []reconcile.Request {
+	list := &databaserv1alpha1.DatabaseMigrationList{}
+	if err := r.Client.List(context.Background(), list, client.InNamespace(o.GetNamespace())); err != nil {
+		r.Log.Error(err, "failed to list migrations", "namespace", o.GetNamespace())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, m := range list.Items {
+		if m.Spec.Source.ConfigMap != nil && m.Spec.Source.ConfigMap.Name == o.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Namespace: m.Namespace, Name: m.Name}})
+		}
+	}
+	return requests
+}
+
+// apply runs the migrations in-process with the credentials of the database user
+func (r *DatabaseMigrationReconciler) apply(ctx context.Context, migration *databaserv1alpha1.DatabaseMigration, db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance, files map[string]string, checksum string) (ctrl.Result, error) {
+	migrations, err := migrate.Load(files)
+	if err != nil {
+		return ctrl.Result{}, r.updateFailedStatus(ctx, migration, checksum, err.Error())
+	}
+	s, _, err := connectInstance(ctx, r.Client, instance)
+	if err != nil {
+		return r.waitFor(ctx, migration, err.Error())
+	}
+	defer s.Close()
+	password, err := databasePassword(ctx, r.Client, r.Vault, db)
+	if err != nil {
+		return r.waitFor(ctx, migration, err.Error())
+	}
+	conn, err := s.ConnectAs(ctx, dbName(db), dbName(db), password)
+	if err != nil {
+		return r.waitFor(ctx, migration, err.Error())
+	}
+	defer conn.Close()
+
+	previous := migration.Status.AppliedVersion
+	version, err := migrate.Run(ctx, conn, migrate.Dialect(instanceEngine(instance)), migration.Spec.TrackingTable, migrations)
+	if version != "" {
+		migration.Status.AppliedVersion = version
+	}
+	if err != nil {
+		return ctrl.Result{}, r.updateFailedStatus(ctx, migration, checksum, err.Error())
+	}
+	if version != previous {
+		r.Recorder.Eventf(migration, v1.EventTypeNormal, "MigrationApplied", "database %s is migrated to version %s", db.Name, version)
+	}
+	return ctrl.Result{}, r.updateCompletedStatus(ctx, migration, checksum)
+}
+
+// runJob starts the job of the current generation and reports its outcome
+func (r *DatabaseMigrationReconciler) runJob(ctx context.Context, migration *databaserv1alpha1.DatabaseMigration, db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance) (ctrl.Result, error) {
+	job := &batchv1.Job{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: migration.Namespace, Name: migrationJobName(migration)}, job); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		if r.OperatorImage == "" {
+			return ctrl.Result{}, r.updateFailedStatus(ctx, migration, "", "operator image is not configured to run migration jobs")
+		}
+		if db.Spec.Vault != nil && db.Spec.Vault.SkipSecret {
+			return ctrl.Result{}, r.updateFailedStatus(ctx, migration, "", "migration job requires the credentials secret of the database")
+		}
+		if usesSSHTunnel(instance) {
+			return ctrl.Result{}, r.updateFailedStatus(ctx, migration, "", "migration job can't reach an instance behind an ssh tunnel, use a configMap source")
+		}
+		job = newMigrationJob(migration, db, instanceEngine(instance), r.OperatorImage)
+		if err := controllerutil.SetControllerReference(migration, job, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Client.Create(ctx, job); err != nil {
+			return ctrl.Result{}, err
+		}
+		migration.Status.Phase = databaserv1alpha1.PhaseRunning
+		migration.Status.LastError = ""
+		r.Recorder.Eventf(migration, v1.EventTypeNormal, "MigrationStarted", "migration job %s is created", job.Name)
+		return ctrl.Result{}, r.Client.Status().Update(ctx, migration)
+	}
+
+	finished, result := jobFinished(job)
+	if !finished {
+		return ctrl.Result{}, nil
+	}
+	if result == batchv1.JobFailed {
+		return ctrl.Result{}, r.updateFailedStatus(ctx, migration, "", fmt.Sprintf("migration job %s failed", job.Name))
+	}
+	jr, err := getJobResult(ctx, r.Client, job, "migrate")
+	if err != nil {
+		return ctrl.Result{}, r.updateFailedStatus(ctx, migration, "", err.Error())
+	}
+	if jr.Version != migration.Status.AppliedVersion {
+		r.Recorder.Eventf(migration, v1.EventTypeNormal, "MigrationApplied", "database %s is migrated to version %s", db.Name, jr.Version)
+	}
+	migration.Status.AppliedVersion = jr.Version
+	return ctrl.Result{}, r.updateCompletedStatus(ctx, migration, "")
+}
+
+// waitFor reports the unmet precondition and checks it again later, it doesn't block the migration
+func (r *DatabaseMigrationReconciler) waitFor(ctx context.Context, migration *databaserv1alpha1.DatabaseMigration, msg string) (ctrl.Result, error) {
+	migration.Status.LastError = msg
+	return ctrl.Result{RequeueAfter: time.Second * 10}, r.Client.Status().Update(ctx, migration)
+}
+
+// updateFailedStatus blocks the migration until the spec or the source checksum changes
+func (r *DatabaseMigrationReconciler) updateFailedStatus(ctx context.Context, migration *databaserv1alpha1.DatabaseMigration, checksum string, msg string) error {
+	r.Recorder.Event(migration, v1.EventTypeWarning, "MigrationFailed", msg)
+	migration.Status.Phase = databaserv1alpha1.PhaseFailed
+	migration.Status.LastError = msg
+	migration.Status.ObservedGeneration = migration.Generation
+	migration.Status.SourceChecksum = checksum
+	return r.Client.Status().Update(ctx, migration)
+}
+
+func (r *DatabaseMigrationReconciler) updateCompletedStatus(ctx context.Context, migration *databaserv1alpha1.DatabaseMigration, checksum string) error {
+	now := metav1.Now()
+	migration.Status.Phase = databaserv1alpha1.PhaseCompleted
+	migration.Status.LastError = ""
+	migration.Status.ObservedGeneration = migration.Generation
+	migration.Status.SourceChecksum = checksum
+	migration.Status.CompletedAt = &now
+	return r.Client.Status().Update(ctx, migration)
+}
+
+// filesChecksum identifies the content of the migration files
+func filesChecksum(files map[string]string) string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(files[name]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
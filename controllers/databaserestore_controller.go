@@ -0,0 +1,212 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// DatabaseRestoreReconciler reconciles a DatabaseRestore object
+type DatabaseRestoreReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// restoreSource is the resolved location of the backup to restore
+type restoreSource struct {
+	storage  databaserv1alpha1.S3Storage
+	url      string
+	checksum string
+}
+
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databaserestores,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databaserestores/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databaserestores/finalizers,verbs=update
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databasebackups,verbs=get;list;watch
+// +kubebuilder:rbac:groups=databaser.slamdev.github.com,resources=databases,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile runs a job restoring the backup into the target database, creating the database first when asked to.
+func (r *DatabaseRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = r.Log.WithValues("databaserestore", req.NamespacedName)
+
+	restore := &databaserv1alpha1.DatabaseRestore{}
+	if err := r.Client.Get(ctx, req.NamespacedName, restore); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if restore.Status.Phase == databaserv1alpha1.PhaseCompleted || restore.Status.Phase == databaserv1alpha1.PhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	job := &batchv1.Job{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restoreJobName(restore)}, job); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		return r.startJob(ctx, restore)
+	}
+
+	finished, result := jobFinished(job)
+	if !finished {
+		return ctrl.Result{}, nil
+	}
+	if result == batchv1.JobFailed {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, restore, fmt.Sprintf("restore job %s failed", job.Name))
+	}
+	now := metav1.Now()
+	restore.Status.Phase = databaserv1alpha1.PhaseCompleted
+	restore.Status.LastError = ""
+	restore.Status.CompletedAt = &now
+	r.Recorder.Eventf(restore, v1.EventTypeNormal, "RestoreCompleted", "backup is restored into database %s", restore.Spec.DatabaseRef.Name)
+	return ctrl.Result{}, r.Client.Status().Update(ctx, restore)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DatabaseRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databaserv1alpha1.DatabaseRestore{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
+
+// startJob creates the restore job once both the backup and the target database are ready
+func (r *DatabaseRestoreReconciler) startJob(ctx context.Context, restore *databaserv1alpha1.DatabaseRestore) (ctrl.Result, error) {
+	source, ready, err := r.resolveSource(ctx, restore)
+	if err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, restore, err.Error())
+	}
+	if !ready {
+		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+	}
+
+	db := &databaserv1alpha1.Database{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.DatabaseRef.Name}, db); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		if restore.Spec.DatabaseTemplate == nil {
+			return ctrl.Result{}, r.updateErrorStatus(ctx, restore, "no corresponding database found")
+		}
+		// the database outlives the restore, so it is not owned by it
+		db = &databaserv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Namespace: restore.Namespace, Name: restore.Spec.DatabaseRef.Name},
+			Spec:       *restore.Spec.DatabaseTemplate,
+		}
+		if err := r.Client.Create(ctx, db); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(restore, v1.EventTypeNormal, "DatabaseCreated", "database %s is created", db.Name)
+		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+	}
+	// the job connects with the credentials of the database, they exist once it is connected
+	if db.Status.Phase != "connected" {
+		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+	}
+
+	instance, err := getInstance(ctx, r.Client, db)
+	if err != nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, restore, err.Error())
+	}
+	if instance == nil {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, restore, "namespace is not allowed to use the database instance")
+	}
+	if db.Spec.Vault != nil && db.Spec.Vault.SkipSecret {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, restore, "restore job requires the credentials secret of the database")
+	}
+	if usesSSHTunnel(instance) {
+		return ctrl.Result{}, r.updateErrorStatus(ctx, restore, "restore job can't reach an instance behind an ssh tunnel")
+	}
+
+	job := newRestoreJob(restore, db, instanceEngine(instance), source)
+	if err := controllerutil.SetControllerReference(restore, job, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.Client.Create(ctx, job); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	restore.Status.Phase = databaserv1alpha1.PhaseRunning
+	restore.Status.StartedAt = &now
+	r.Recorder.Eventf(restore, v1.EventTypeNormal, "RestoreStarted", "restore job %s is created", job.Name)
+	return ctrl.Result{}, r.Client.Status().Update(ctx, restore)
+}
+
+// resolveSource returns the location of the backup, it is not ready until the referenced backup is completed
+func (r *DatabaseRestoreReconciler) resolveSource(ctx context.Context, restore *databaserv1alpha1.DatabaseRestore) (restoreSource, bool, error) {
+	spec := restore.Spec.Source
+	if (spec.BackupRef == nil) == (spec.S3 == nil) {
+		return restoreSource{}, false, fmt.Errorf("exactly one of backupRef or s3 source should be defined")
+	}
+	if spec.S3 != nil {
+		return restoreSource{
+			storage: databaserv1alpha1.S3Storage{
+				Endpoint:              spec.S3.Endpoint,
+				Region:                spec.S3.Region,
+				CredentialsSecretName: spec.S3.CredentialsSecretName,
+			},
+			url: spec.S3.URL,
+		}, true, nil
+	}
+
+	backup := &databaserv1alpha1.DatabaseBackup{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: spec.BackupRef.Name}, backup); err != nil {
+		if errors.IsNotFound(err) {
+			return restoreSource{}, false, fmt.Errorf("no corresponding backup found")
+		}
+		return restoreSource{}, false, err
+	}
+	switch backup.Status.Phase {
+	case databaserv1alpha1.PhaseFailed:
+		return restoreSource{}, false, fmt.Errorf("backup %s is failed", backup.Name)
+	case databaserv1alpha1.PhaseCompleted:
+		return restoreSource{
+			storage:  backup.Spec.Storage.S3,
+			url:      backup.Status.Location,
+			checksum: backup.Status.Checksum,
+		}, true, nil
+	}
+	return restoreSource{}, false, nil
+}
+
+func (r *DatabaseRestoreReconciler) updateErrorStatus(ctx context.Context, restore *databaserv1alpha1.DatabaseRestore, msg string) error {
+	r.Recorder.Event(restore, v1.EventTypeWarning, "RestoreFailed", msg)
+	restore.Status.Phase = databaserv1alpha1.PhaseFailed
+	restore.Status.LastError = msg
+	return r.Client.Status().Update(ctx, restore)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"github.com/slamdev/databaser/pkg"
+	v1 "k8s.io/api/core/v1"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// syncExtensions installs the extensions of the spec with the admin connection and reports their versions,
+// an extension removed from the spec stays installed since the objects of the database may depend on it.
+func (r *DatabaseReconciler) syncExtensions(ctx context.Context, db *databaserv1alpha1.Database, s pkg.Server) error {
+	var installed []databaserv1alpha1.InstalledExtension
+	for _, extension := range db.Spec.Extensions {
+		version, err := s.EnsureExtension(ctx, dbName(db), pkg.Extension{Name: extension.Name, Version: extension.Version, Schema: extension.Schema})
+		if err != nil {
+			return err
+		}
+		previous := findInstalledExtension(db.Status.Extensions, extension.Name)
+		if previous == nil {
+			r.Recorder.Eventf(db, v1.EventTypeNormal, "ExtensionInstalled", "extension %s %s is installed", extension.Name, version)
+		} else if previous.Version != version {
+			r.Recorder.Eventf(db, v1.EventTypeNormal, "ExtensionUpdated", "extension %s is updated from %s to %s", extension.Name, previous.Version, version)
+		}
+		installed = append(installed, databaserv1alpha1.InstalledExtension{Name: extension.Name, Version: version})
+	}
+	db.Status.Extensions = installed
+	return nil
+}
+
+func findInstalledExtension(extensions []databaserv1alpha1.InstalledExtension, name string) *databaserv1alpha1.InstalledExtension {
+	for i := range extensions {
+		if extensions[i].Name == name {
+			return &extensions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/slamdev/databaser/pkg"
+	"github.com/slamdev/databaser/pkg/migrate"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// markInitPending schedules the init SQL of a database the operator has just created
+func markInitPending(db *databaserv1alpha1.Database) {
+	if db.Spec.InitSQL == nil {
+		return
+	}
+	meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+		Type:               databaserv1alpha1.ConditionInitialized,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: db.Generation,
+		Reason:             "Pending",
+		Message:            "database is created, init SQL is not run yet",
+	})
+}
+
+// runInitSQL runs the pending init SQL once. A failure is recorded in the condition instead of failing the
+// database since the script may be applied partially, it is retried only when the spec changes. The run is
+// recorded before the script starts and its result right after it ends, a run interrupted in between is
+// failed since it can't be known how much of the script is applied.
+func (r *DatabaseReconciler) runInitSQL(ctx context.Context, db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance, s pkg.Server) error {
+	c := meta.FindStatusCondition(db.Status.Conditions, databaserv1alpha1.ConditionInitialized)
+	if c == nil || c.Status == metav1.ConditionTrue || db.Spec.InitSQL == nil {
+		return nil
+	}
+	if c.Reason == "Running" {
+		r.Recorder.Event(db, v1.EventTypeWarning, "InitSQLFailed", "init SQL was interrupted")
+		return r.setInitialized(ctx, db, metav1.ConditionFalse, "Failed", "init SQL was interrupted, its result is unknown")
+	}
+	if c.Reason == "Failed" && c.ObservedGeneration >= db.Generation {
+		return nil
+	}
+	script, err := r.initScript(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	var conn *sql.DB
+	if db.Spec.InitSQL.RunAs == databaserv1alpha1.InitSQLRunAsAdmin {
+		conn, err = s.Connect(ctx, dbName(db))
+	} else {
+		var password string
+		if password, err = databasePassword(ctx, r.Client, r.Vault, db); err != nil {
+			return err
+		}
+		conn, err = s.ConnectAs(ctx, dbName(db), dbName(db), password)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := r.setInitialized(ctx, db, metav1.ConditionFalse, "Running", "init SQL is running"); err != nil {
+		return err
+	}
+	if err := migrate.Exec(ctx, conn, migrate.Dialect(instanceEngine(instance)), script); err != nil {
+		r.Recorder.Eventf(db, v1.EventTypeWarning, "InitSQLFailed", "init SQL failed; %s", err)
+		return r.setInitialized(ctx, db, metav1.ConditionFalse, "Failed", err.Error())
+	}
+	r.Recorder.Event(db, v1.EventTypeNormal, "InitSQLApplied", "init SQL is applied")
+	return r.setInitialized(ctx, db, metav1.ConditionTrue, "Succeeded", "init SQL is applied")
+}
+
+// setInitialized saves the state of the init SQL right away, it shouldn't be lost with a later failure
+func (r *DatabaseReconciler) setInitialized(ctx context.Context, db *databaserv1alpha1.Database, status metav1.ConditionStatus, reason string, msg string) error {
+	meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
+		Type:               databaserv1alpha1.ConditionInitialized,
+		Status:             status,
+		ObservedGeneration: db.Generation,
+		Reason:             reason,
+		Message:            msg,
+	})
+	return r.Client.Status().Update(ctx, db)
+}
+
+// initScript returns the inline script or reads it from the ConfigMap
+func (r *DatabaseReconciler) initScript(ctx context.Context, db *databaserv1alpha1.Database) (string, error) {
+	spec := db.Spec.InitSQL
+	if (spec.SQL == "") == (spec.ConfigMapKeyRef == nil) {
+		return "", fmt.Errorf("exactly one of sql or configMapKeyRef init SQL should be defined")
+	}
+	if spec.ConfigMapKeyRef == nil {
+		return spec.SQL, nil
+	}
+	cm := &v1.ConfigMap{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: db.Namespace, Name: spec.ConfigMapKeyRef.Name}, cm); err != nil {
+		return "", fmt.Errorf("failed to get init SQL configmap %s; %w", spec.ConfigMapKeyRef.Name, err)
+	}
+	script, ok := cm.Data[spec.ConfigMapKeyRef.Key]
+	if !ok {
+		return "", fmt.Errorf("key %s is not found in configmap %s", spec.ConfigMapKeyRef.Key, cm.Name)
+	}
+	return script, nil
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/slamdev/databaser/pkg"
+	"github.com/slamdev/databaser/pkg/clickhouse"
+	"github.com/slamdev/databaser/pkg/postgres"
+	"github.com/slamdev/databaser/pkg/secrets"
+	"github.com/slamdev/databaser/pkg/tunnel"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/jsonpath"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+	"strings"
+	"time"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// reconcileInstance checks the connection to either kind of instance and reports it in the status
+func reconcileInstance(ctx context.Context, c client.Client, recorder record.EventRecorder, instance databaserv1alpha1.GenericDatabaseInstance) (ctrl.Result, error) {
+	total, perNamespace, err := countDatabases(ctx, c, instance, nil)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	instance.GetStatus().Databases = total
+	instance.GetStatus().DatabasesPerNamespace = perNamespace
+	users, err := countUsers(ctx, c, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	instance.GetStatus().UsersPerDatabase = users
+
+	s, params, err := connectInstance(ctx, c, instance)
+	if err != nil {
+		return ctrl.Result{}, updateInstanceErrorStatus(ctx, c, recorder, instance, err.Error())
+	}
+	defer s.Close()
+	if err := collectInventory(ctx, c, recorder, instance, s, params); err != nil {
+		return ctrl.Result{}, updateInstanceErrorStatus(ctx, c, recorder, instance, err.Error())
+	}
+
+	return ctrl.Result{RequeueAfter: time.Second * 60}, updateInstanceConnectedStatus(ctx, c, recorder, instance)
+}
+
+func updateInstanceErrorStatus(ctx context.Context, c client.Client, recorder record.EventRecorder, instance databaserv1alpha1.GenericDatabaseInstance, msg string) error {
+	status := instance.GetStatus()
+	if status.Phase == "connected" {
+		recorder.Event(instance, v1.EventTypeWarning, "Disconnected", msg)
+	} else {
+		recorder.Event(instance, v1.EventTypeWarning, "Failed", msg)
+	}
+	status.Phase = "failed"
+	status.LastError = msg
+	return c.Status().Update(ctx, instance)
+}
+
+func updateInstanceConnectedStatus(ctx context.Context, c client.Client, recorder record.EventRecorder, instance databaserv1alpha1.GenericDatabaseInstance) error {
+	status := instance.GetStatus()
+	if status.Phase != "connected" {
+		recorder.Event(instance, v1.EventTypeNormal, "Connected", "connection to the instance is established")
+	}
+	status.Phase = "connected"
+	status.LastError = ""
+	return c.Status().Update(ctx, instance)
+}
+
+// getInstance returns the referenced instance or nil when the database namespace is not allowed to use it
+func getInstance(ctx context.Context, c client.Client, db *databaserv1alpha1.Database) (databaserv1alpha1.GenericDatabaseInstance, error) {
+	ref := db.Spec.DatabaseInstanceRef
+	if ref.Kind != "ClusterDatabaseInstance" {
+		instance := &databaserv1alpha1.DatabaseInstance{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: db.Namespace, Name: ref.Name}, instance); err != nil {
+			return nil, err
+		}
+		return instance, nil
+	}
+
+	instance := &databaserv1alpha1.ClusterDatabaseInstance{}
+	if err := c.Get(ctx, client.ObjectKey{Name: ref.Name}, instance); err != nil {
+		return nil, err
+	}
+	if instance.Spec.AllowedNamespaces == nil {
+		return instance, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(instance.Spec.AllowedNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	ns := &v1.Namespace{}
+	if err := c.Get(ctx, client.ObjectKey{Name: db.Namespace}, ns); err != nil {
+		return nil, err
+	}
+	if !selector.Matches(labels.Set(ns.Labels)) {
+		return nil, nil
+	}
+	return instance, nil
+}
+
+// connectInstance opens an administrative connection to the instance and returns it
+// together with the resolved connection params.
+func connectInstance(ctx context.Context, c client.Client, instance databaserv1alpha1.GenericDatabaseInstance) (pkg.Server, databaserv1alpha1.SqlParams, error) {
+	spec := instance.GetSpec()
+	if spec.Clikhouse != nil && spec.Postgres != nil {
+		return nil, databaserv1alpha1.SqlParams{}, fmt.Errorf("only one connection spec is allowed")
+	}
+	if spec.Clikhouse != nil {
+		return connectClickhouse(ctx, c, instance.GetNamespace(), *spec.Clikhouse)
+	}
+	if spec.Postgres != nil {
+		return connectPostgres(ctx, c, instance.GetNamespace(), *spec.Postgres)
+	}
+	return nil, databaserv1alpha1.SqlParams{}, fmt.Errorf("at least one connection spec should be defined")
+}
+
+func connectClickhouse(ctx context.Context, c client.Client, namespace string, spec databaserv1alpha1.ClikhouseSpec) (pkg.Server, databaserv1alpha1.SqlParams, error) {
+	sqlParams, err := parseSqlParams(ctx, c, namespace, spec.SqlParams)
+	if err != nil {
+		return nil, databaserv1alpha1.SqlParams{}, err
+	}
+	sshTunnel, err := parseSSHTunnel(ctx, c, namespace, sqlParams.SSHTunnel)
+	if err != nil {
+		return nil, databaserv1alpha1.SqlParams{}, err
+	}
+	s, err := pkg.ConnectClickhouse(ctx, clickhouse.Params{
+		User:     sqlParams.Username,
+		Password: sqlParams.Password,
+		Host:     sqlParams.Host,
+		Port:     sqlParams.Port,
+		Tunnel:   sshTunnel,
+	})
+	return s, sqlParams, err
+}
+
+func connectPostgres(ctx context.Context, c client.Client, namespace string, spec databaserv1alpha1.PostgresSpec) (pkg.Server, databaserv1alpha1.SqlParams, error) {
+	sqlParams, err := parseSqlParams(ctx, c, namespace, spec.SqlParams)
+	if err != nil {
+		return nil, databaserv1alpha1.SqlParams{}, err
+	}
+	if spec.AuthDBRef != nil {
+		if spec.AuthDB, err = getParamValue(ctx, c, namespace, *spec.AuthDBRef, "authdb"); err != nil {
+			return nil, databaserv1alpha1.SqlParams{}, err
+		}
+	}
+	sshTunnel, err := parseSSHTunnel(ctx, c, namespace, sqlParams.SSHTunnel)
+	if err != nil {
+		return nil, databaserv1alpha1.SqlParams{}, err
+	}
+	params := postgres.Params{
+		User:     sqlParams.Username,
+		Password: sqlParams.Password,
+		Host:     sqlParams.Host,
+		Port:     sqlParams.Port,
+		AuthDB:   spec.AuthDB,
+		Tunnel:   sshTunnel,
+	}
+	if spec.Auth == databaserv1alpha1.PostgresAuthIAMToken {
+		if params.TokenProvider, err = postgres.GetTokenProvider(spec.TokenProvider); err != nil {
+			return nil, databaserv1alpha1.SqlParams{}, err
+		}
+	}
+	s, err := pkg.ConnectPostgres(ctx, params)
+	return s, sqlParams, err
+}
+
+// adminDatabase is the database the admin of a postgres instance logs in to
+func adminDatabase(ctx context.Context, c client.Client, instance databaserv1alpha1.GenericDatabaseInstance) (string, error) {
+	spec := instance.GetSpec().Postgres
+	if spec == nil {
+		return "", nil
+	}
+	if spec.AuthDBRef != nil {
+		return getParamValue(ctx, c, instance.GetNamespace(), *spec.AuthDBRef, "authdb")
+	}
+	return spec.AuthDB, nil
+}
+
+func parseSqlParams(ctx context.Context, c client.Client, namespace string, params databaserv1alpha1.SqlParams) (databaserv1alpha1.SqlParams, error) {
+	var err error
+	if params.HostRef != nil {
+		if params.Host, err = getParamValue(ctx, c, namespace, *params.HostRef, "hostname", "host"); err != nil {
+			return databaserv1alpha1.SqlParams{}, err
+		}
+	}
+	if params.PortRef != nil {
+		var port string
+		if port, err = getParamValue(ctx, c, namespace, *params.PortRef, "port"); err != nil {
+			return databaserv1alpha1.SqlParams{}, err
+		}
+		if params.Port, err = strconv.Atoi(port); err != nil {
+			return databaserv1alpha1.SqlParams{}, err
+		}
+	}
+	if params.UsernameRef != nil {
+		if params.Username, err = getParamValue(ctx, c, namespace, *params.UsernameRef, "user", "username"); err != nil {
+			return databaserv1alpha1.SqlParams{}, err
+		}
+	}
+	if params.PasswordRef != nil {
+		if params.Password, err = getParamValue(ctx, c, namespace, *params.PasswordRef, "pass", "password"); err != nil {
+			return databaserv1alpha1.SqlParams{}, err
+		}
+	}
+	return params, nil
+}
+
+// parseSSHTunnel resolves the bastion the instance is reached through, nil when there is none
+func parseSSHTunnel(ctx context.Context, c client.Client, namespace string, t *databaserv1alpha1.SSHTunnel) (*tunnel.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+	privateKey, err := getParamValue(ctx, c, namespace, t.PrivateKeyRef, "ssh-privatekey", "id_rsa", "id_ed25519")
+	if err != nil {
+		return nil, err
+	}
+	knownHosts, err := getParamValue(ctx, c, namespace, t.KnownHostsRef, "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	return &tunnel.Config{
+		Host:       t.Host,
+		Port:       t.Port,
+		User:       t.User,
+		PrivateKey: privateKey,
+		KnownHosts: knownHosts,
+	}, nil
+}
+
+// getParamValue reads the referenced value, the namespace of a namespaced instance confines the
+// reference to that namespace and is empty for a cluster instance.
+func getParamValue(ctx context.Context, c client.Client, namespace string, ref databaserv1alpha1.ParamRef, fallbacks ...string) (string, error) {
+	if ref.Provider != "" {
+		return getProviderValue(ctx, namespace, ref, fallbacks)
+	}
+	if namespace != "" {
+		if ref.Namespace != "" && ref.Namespace != namespace {
+			return "", fmt.Errorf("reference to %s/%s is outside of the instance namespace", ref.Namespace, ref.Name)
+		}
+		ref.Namespace = namespace
+	} else if ref.Namespace == "" {
+		return "", fmt.Errorf("namespace is required for reference to %s", ref.Name)
+	}
+
+	if ref.FieldPath != "" {
+		return getFieldValue(ctx, c, ref)
+	}
+
+	var keys []string
+	if ref.Key != "" {
+		keys = []string{ref.Key}
+	} else {
+		keys = fallbacks
+	}
+
+	var data map[string]string
+	if ref.Kind == "ConfigMap" {
+		instance := &v1.ConfigMap{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, instance); err != nil {
+			return "", fmt.Errorf("failed to get %s %s/%s; %w", ref.Kind, ref.Namespace, ref.Name, err)
+		}
+		data = instance.Data
+	} else if ref.Kind == "Secret" {
+		instance := &v1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, instance); err != nil {
+			return "", fmt.Errorf("failed to get %s %s/%s; %w", ref.Kind, ref.Namespace, ref.Name, err)
+		}
+		data = map[string]string{}
+		for k, v := range instance.Data {
+			data[k] = string(v)
+		}
+	} else {
+		return "", fmt.Errorf("fieldPath is required to read %s kind", ref.Kind)
+	}
+	for _, key := range keys {
+		if val, ok := data[key]; ok {
+			return val, nil
+		}
+	}
+	return "", fmt.Errorf("none of keys %v is found in %s %s/%s", keys, ref.Kind, ref.Namespace, ref.Name)
+}
+
+// getProviderValue reads the value from a secret provider, the namespace of the instance scopes what it can read
+func getProviderValue(ctx context.Context, namespace string, ref databaserv1alpha1.ParamRef, fallbacks []string) (string, error) {
+	provider, err := secrets.Get(ref.Provider)
+	if err != nil {
+		return "", err
+	}
+	keys := fallbacks
+	if ref.Key != "" {
+		keys = []string{ref.Key}
+	}
+	// the name alone may hold the value when no key is set
+	if ref.Key == "" {
+		keys = append([]string{""}, keys...)
+	}
+	for _, key := range keys {
+		val, err := provider.Value(ctx, namespace, ref.Name, key)
+		if err == nil {
+			return val, nil
+		}
+		if !errors.Is(err, secrets.ErrNotFound) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("none of keys %v is found in %s secret %s", keys, ref.Provider, ref.Name)
+}
+
+// getFieldValue reads the value at the JSONPath of an object of any kind
+func getFieldValue(ctx context.Context, c client.Client, ref databaserv1alpha1.ParamRef) (string, error) {
+	apiVersion := ref.APIVersion
+	if apiVersion == "" {
+		apiVersion = "v1"
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(ref.Kind)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, obj); err != nil {
+		if apierrors.IsForbidden(err) {
+			return "", fmt.Errorf("operator is not allowed to read %s %s/%s, grant it in field-reader-role; %w", ref.Kind, ref.Namespace, ref.Name, err)
+		}
+		return "", fmt.Errorf("failed to get %s %s/%s; %w", ref.Kind, ref.Namespace, ref.Name, err)
+	}
+
+	path := ref.FieldPath
+	if !strings.HasPrefix(path, "{") {
+		path = "{" + path + "}"
+	}
+	jp := jsonpath.New(ref.Name)
+	if err := jp.Parse(path); err != nil {
+		return "", fmt.Errorf("failed to parse fieldPath %s; %w", ref.FieldPath, err)
+	}
+	results, err := jp.FindResults(obj.Object)
+	if err != nil || len(results) == 0 || len(results[0]) == 0 {
+		return "", fmt.Errorf("field %s is not found in %s %s/%s", ref.FieldPath, ref.Kind, ref.Namespace, ref.Name)
+	}
+	buf := bytes.Buffer{}
+	if err := jp.PrintResults(&buf, results[0]); err != nil {
+		return "", fmt.Errorf("failed to print field %s of %s %s/%s; %w", ref.FieldPath, ref.Kind, ref.Namespace, ref.Name, err)
+	}
+	return buf.String(), nil
+}
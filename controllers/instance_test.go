@@ -0,0 +1,84 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+func TestGetFieldValue(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "db"},
+			Spec: v1.ServiceSpec{
+				ClusterIP: "10.0.0.12",
+				Ports:     []v1.ServicePort{{Name: "sql", Port: 5432}},
+			},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "db"},
+			Data:       map[string][]byte{"host": []byte("db.local")},
+		},
+	).Build()
+	for name, tc := range map[string]struct {
+		ref     databaserv1alpha1.ParamRef
+		want    string
+		wantErr bool
+	}{
+		"field": {
+			ref:  databaserv1alpha1.ParamRef{Kind: "Service", Name: "postgres", FieldPath: "{.spec.clusterIP}"},
+			want: "10.0.0.12",
+		},
+		"field without braces": {
+			ref:  databaserv1alpha1.ParamRef{Kind: "Service", Name: "postgres", FieldPath: ".spec.ports[0].port"},
+			want: "5432",
+		},
+		"secret value is encoded": {
+			ref:  databaserv1alpha1.ParamRef{Kind: "Secret", Name: "postgres", FieldPath: "{.data.host}"},
+			want: "ZGIubG9jYWw=",
+		},
+		"missing field": {
+			ref:     databaserv1alpha1.ParamRef{Kind: "Service", Name: "postgres", FieldPath: "{.spec.externalName}"},
+			wantErr: true,
+		},
+		"missing object": {
+			ref:     databaserv1alpha1.ParamRef{Kind: "Service", Name: "mysql", FieldPath: "{.spec.clusterIP}"},
+			wantErr: true,
+		},
+		"invalid path": {
+			ref:     databaserv1alpha1.ParamRef{Kind: "Service", Name: "postgres", FieldPath: "{.spec["},
+			wantErr: true,
+		},
+	} {
+		got, err := getParamValue(context.Background(), c, "db", tc.ref)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", name, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", name, got, tc.want)
+		}
+	}
+}
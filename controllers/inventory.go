@@ -0,0 +1,154 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/slamdev/databaser/pkg"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	"path"
+	"regexp"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+var invalidObjectNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// collectInventory reports the databases and users of the instance that no Database manages and
+// creates databases adopting them when asked to.
+func collectInventory(ctx context.Context, c client.Client, recorder record.EventRecorder, instance databaserv1alpha1.GenericDatabaseInstance, s pkg.Server, params databaserv1alpha1.SqlParams) error {
+	spec := instance.GetSpec().Inventory
+	if spec == nil {
+		instance.GetStatus().Unmanaged = nil
+		return nil
+	}
+
+	dbs, err := listDatabases(ctx, c, instance)
+	if err != nil {
+		return err
+	}
+	managedDatabases := map[string]bool{}
+	managedUsers := map[string]bool{params.Username: true}
+	for i := range dbs {
+		managedDatabases[dbName(&dbs[i])] = true
+		for _, user := range databaseUsers(&dbs[i]) {
+			managedUsers[user] = true
+		}
+	}
+
+	databases, err := s.ListDatabases(ctx)
+	if err != nil {
+		return err
+	}
+	users, err := s.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	inventory := &databaserv1alpha1.InstanceInventory{CollectedAt: metav1.Now()}
+	for _, name := range databases {
+		if !managedDatabases[name] {
+			inventory.Databases = append(inventory.Databases, name)
+		}
+	}
+	for _, name := range users {
+		if !managedUsers[name] {
+			inventory.Users = append(inventory.Users, name)
+		}
+	}
+	instance.GetStatus().Unmanaged = inventory
+
+	if spec.AutoAdopt == nil {
+		return nil
+	}
+	authDB, err := adminDatabase(ctx, c, instance)
+	if err != nil {
+		return err
+	}
+	for _, name := range inventory.Databases {
+		selected, err := selectedForAdoption(*spec.AutoAdopt, name)
+		if err != nil {
+			return err
+		}
+		if !selected || checkReservedName(instance, []string{params.Username, authDB}, name) != nil {
+			continue
+		}
+		// names that need quoting are left to be adopted by hand
+		if validateDatabaseName(instanceEngine(instance), name) != nil {
+			continue
+		}
+		if err := adoptUnmanaged(ctx, c, recorder, instance, *spec.AutoAdopt, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectedForAdoption tells whether the name matches one of the patterns of the databases to adopt
+func selectedForAdoption(spec databaserv1alpha1.AutoAdoptSpec, name string) (bool, error) {
+	for _, pattern := range spec.Databases {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %s of adopted databases; %w", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// adoptUnmanaged creates a Database adopting the unmanaged database, names that can't be turned
+// into object names are left to be adopted by hand.
+func adoptUnmanaged(ctx context.Context, c client.Client, recorder record.EventRecorder, instance databaserv1alpha1.GenericDatabaseInstance, spec databaserv1alpha1.AutoAdoptSpec, name string) error {
+	objectName := strings.Trim(invalidObjectNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+	if len(validation.IsDNS1123Subdomain(objectName)) > 0 {
+		return nil
+	}
+	ref := databaserv1alpha1.DatabaseInstanceRef{Kind: "DatabaseInstance", Name: instance.GetName()}
+	namespace := instance.GetNamespace()
+	if namespace == "" {
+		if spec.Namespace == "" {
+			return fmt.Errorf("namespace is required to adopt databases of a cluster instance")
+		}
+		ref.Kind = "ClusterDatabaseInstance"
+		namespace = spec.Namespace
+	}
+	db := &databaserv1alpha1.Database{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: objectName, Labels: spec.Labels},
+		Spec: databaserv1alpha1.DatabaseSpec{
+			DatabaseInstanceRef: ref,
+			DatabaseName:        name,
+			Adopt:               databaserv1alpha1.AdoptIfExists,
+		},
+	}
+	if err := c.Create(ctx, db); err != nil {
+		// an object with the same name referring to another instance is left alone
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to create database %s/%s; %w", namespace, objectName, err)
+	}
+	recorder.Eventf(instance, v1.EventTypeNormal, "DatabaseDiscovered", "database %s/%s is created to adopt unmanaged database %s", namespace, objectName, name)
+	return nil
+}
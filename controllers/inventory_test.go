@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+func TestSelectedForAdoption(t *testing.T) {
+	spec := databaserv1alpha1.AutoAdoptSpec{Databases: []string{"orders", "app_*"}}
+	for name, want := range map[string]bool{
+		"orders":      true,
+		"orders_v2":   false,
+		"app_billing": true,
+		"app":         false,
+		"my_app_logs": false,
+	} {
+		got, err := selectedForAdoption(spec, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	if _, err := selectedForAdoption(databaserv1alpha1.AutoAdoptSpec{Databases: []string{"app_["}}, "app_billing"); err == nil {
+		t.Error("invalid pattern is accepted")
+	}
+}
+
+func TestAdoptUnmanaged(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := databaserv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	namespaced := &databaserv1alpha1.DatabaseInstance{ObjectMeta: metav1.ObjectMeta{Name: "main", Namespace: "shop"}}
+	cluster := &databaserv1alpha1.ClusterDatabaseInstance{ObjectMeta: metav1.ObjectMeta{Name: "main"}}
+	for name, tc := range map[string]struct {
+		instance  databaserv1alpha1.GenericDatabaseInstance
+		namespace string
+		database  string
+		existing  []client.Object
+		want      *client.ObjectKey
+		wantKind  string
+		wantErr   bool
+	}{
+		"namespaced instance": {
+			instance: namespaced,
+			database: "Orders_DB",
+			want:     &client.ObjectKey{Namespace: "shop", Name: "orders-db"},
+			wantKind: "DatabaseInstance",
+		},
+		"cluster instance": {
+			instance:  cluster,
+			namespace: "legacy",
+			database:  "orders",
+			want:      &client.ObjectKey{Namespace: "legacy", Name: "orders"},
+			wantKind:  "ClusterDatabaseInstance",
+		},
+		"cluster instance without namespace": {
+			instance: cluster,
+			database: "orders",
+			wantErr:  true,
+		},
+		"no object name": {
+			instance: namespaced,
+			database: "__",
+		},
+		"object exists": {
+			instance: namespaced,
+			database: "orders",
+			existing: []client.Object{&databaserv1alpha1.Database{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"}}},
+		},
+	} {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tc.existing...).Build()
+		spec := databaserv1alpha1.AutoAdoptSpec{Namespace: tc.namespace, Labels: map[string]string{"team": "legacy"}}
+		err := adoptUnmanaged(context.Background(), c, record.NewFakeRecorder(10), tc.instance, spec, tc.database)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", name, err, tc.wantErr)
+			continue
+		}
+		list := &databaserv1alpha1.DatabaseList{}
+		if err := c.List(context.Background(), list); err != nil {
+			t.Fatal(err)
+		}
+		if tc.want == nil {
+			if len(list.Items) != len(tc.existing) {
+				t.Errorf("%s: got %d databases, want none created", name, len(list.Items))
+			}
+			continue
+		}
+		db := &databaserv1alpha1.Database{}
+		if err := c.Get(context.Background(), *tc.want, db); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if db.Spec.DatabaseName != tc.database || db.Spec.Adopt != databaserv1alpha1.AdoptIfExists || db.Labels["team"] != "legacy" {
+			t.Errorf("%s: got spec %+v and labels %v", name, db.Spec, db.Labels)
+		}
+		if db.Spec.DatabaseInstanceRef.Kind != tc.wantKind || db.Spec.DatabaseInstanceRef.Name != "main" {
+			t.Errorf("%s: got instance ref %+v", name, db.Spec.DatabaseInstanceRef)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"path"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+const (
+	defaultPostgresImage   = "postgres:13"
+	defaultClickhouseImage = "yandex/clickhouse-client:21.3"
+	defaultS3Image         = "amazon/aws-cli:2.2.0"
+)
+
+const postgresDumpScript = `set -e
+pg_dump --format=custom --no-owner --file=/backup/dump "$DB_NAME"`
+
+const clickhouseDumpScript = `set -e
+ch() { clickhouse-client --host "$DB_HOST" --port "$DB_PORT" --user "$DB_USER" --password "$DB_PASSWORD" "$@"; }
+mkdir -p /tmp/dump
+for t in $(ch --query "SHOW TABLES FROM \"$DB_NAME\""); do
+  ch --format TSVRaw --query "SHOW CREATE TABLE \"$DB_NAME\".\"$t\"" > "/tmp/dump/$t.sql"
+  ch --query "SELECT * FROM \"$DB_NAME\".\"$t\" FORMAT Native" > "/tmp/dump/$t.native"
+done
+tar -C /tmp/dump -cf /backup/dump .`
+
+const s3UploadScript = `set -e
+if [ -n "$S3_ENDPOINT" ]; then aws configure set default.s3.addressing_style path; fi
+size=$(stat -c %s /backup/dump)
+checksum=$(sha256sum /backup/dump | cut -d ' ' -f 1)
+aws s3 cp /backup/dump "$S3_URL" ${S3_ENDPOINT:+--endpoint-url "$S3_ENDPOINT"}
+printf '{"sizeBytes":%s,"checksum":"%s"}' "$size" "$checksum" > /dev/termination-log`
+
+const s3DeleteScript = `set -e
+if [ -n "$S3_ENDPOINT" ]; then aws configure set default.s3.addressing_style path; fi
+aws s3 rm "$S3_URL" ${S3_ENDPOINT:+--endpoint-url "$S3_ENDPOINT"}`
+
+const s3DownloadScript = `set -e
+if [ -n "$S3_ENDPOINT" ]; then aws configure set default.s3.addressing_style path; fi
+aws s3 cp "$S3_URL" /backup/dump ${S3_ENDPOINT:+--endpoint-url "$S3_ENDPOINT"}
+if [ -n "$CHECKSUM" ]; then echo "$CHECKSUM  /backup/dump" | sha256sum -c -; fi`
+
+// objects are created by the user of the target database, so it owns everything restored
+const postgresRestoreScript = `set -e
+pg_restore --no-owner --no-privileges --clean --if-exists --exit-on-error --dbname="$DB_NAME" /backup/dump`
+
+// tables are created before views and the database name in the definitions is replaced by the target one
+const clickhouseRestoreScript = `set -e
+ch() { clickhouse-client --host "$DB_HOST" --port "$DB_PORT" --user "$DB_USER" --password "$DB_PASSWORD" --database "$DB_NAME" "$@"; }
+mkdir -p /tmp/dump
+tar -C /tmp/dump -xf /backup/dump
+restore() {
+  t=$(basename "$1" .sql)
+  ch --query "DROP TABLE IF EXISTS \"$t\""
+  sed -E '1s/^(CREATE [A-Z ]+) [^ .]+\./\1 /' "$1" | ch --multiquery
+}
+for f in /tmp/dump/*.sql; do
+  [ -e "$f" ] || continue
+  if head -n 1 "$f" | grep -q '^CREATE TABLE'; then
+    restore "$f"
+    ch --query "INSERT INTO \"$(basename "$f" .sql)\" FORMAT Native" < "${f%.sql}.native"
+  fi
+done
+for f in /tmp/dump/*.sql; do
+  [ -e "$f" ] || continue
+  if ! head -n 1 "$f" | grep -q '^CREATE TABLE'; then restore "$f"; fi
+done`
+
+// the files are copied with the shell of the source image since the operator image has none
+const copyMigrationsScript = `set -e
+cp -R "$MIGRATIONS_PATH"/. /migrations/`
+
+// jobResult is reported by the last container of a job in its termination message
+type jobResult struct {
+	SizeBytes int64  `json:"sizeBytes"`
+	Checksum  string `json:"checksum"`
+	Version   string `json:"version"`
+}
+
+// instanceEngine returns the engine of the instance, postgres or clickhouse
+func instanceEngine(instance databaserv1alpha1.GenericDatabaseInstance) string {
+	if instance.GetSpec().Clikhouse != nil {
+		return "clickhouse"
+	}
+	return "postgres"
+}
+
+// usesSSHTunnel tells whether the instance is reached through a bastion, the tunnel lives in the operator
+// and the pods of the jobs can't connect through it
+func usesSSHTunnel(instance databaserv1alpha1.GenericDatabaseInstance) bool {
+	spec := instance.GetSpec()
+	if spec.Clikhouse != nil {
+		return spec.Clikhouse.SSHTunnel != nil
+	}
+	return spec.Postgres != nil && spec.Postgres.SSHTunnel != nil
+}
+
+// backupURL is the location of the backup in the object storage
+func backupURL(storage databaserv1alpha1.S3Storage, backup *databaserv1alpha1.DatabaseBackup, engine string) string {
+	ext := ".dump"
+	if engine == "clickhouse" {
+		ext = ".tar"
+	}
+	key := path.Join(storage.Prefix, backup.Namespace, backup.Spec.DatabaseRef.Name, backup.Name+ext)
+	return fmt.Sprintf("s3://%s/%s", storage.Bucket, key)
+}
+
+// jobName joins the name of the object and the suffix of the job. The job-name label of the pods limits it
+// to a label value, a longer name is truncated and suffixed with a hash of the whole name to stay unique.
+func jobName(name string, suffix string) string {
+	full := name + "-" + suffix
+	if len(full) <= validation.LabelValueMaxLength {
+		return full
+	}
+	sum := sha256.Sum256([]byte(full))
+	prefix := strings.TrimRight(name[:validation.LabelValueMaxLength-len(suffix)-10], "-.")
+	return prefix + "-" + hex.EncodeToString(sum[:])[:8] + "-" + suffix
+}
+
+func backupJobName(backup *databaserv1alpha1.DatabaseBackup) string {
+	return jobName(backup.Name, "backup")
+}
+
+// newBackupJob creates a job dumping the database with its own credentials and uploading the dump to the storage
+func newBackupJob(backup *databaserv1alpha1.DatabaseBackup, db *databaserv1alpha1.Database, engine string) *batchv1.Job {
+	dump := v1.Container{
+		Name:         "dump",
+		Env:          databaseEnv(db),
+		VolumeMounts: []v1.VolumeMount{{Name: "backup", MountPath: "/backup"}},
+	}
+	if engine == "clickhouse" {
+		dump.Image = defaultClickhouseImage
+		dump.Command = []string{"/bin/sh", "-c", clickhouseDumpScript}
+	} else {
+		dump.Image = defaultPostgresImage
+		dump.Command = []string{"/bin/sh", "-c", postgresDumpScript}
+	}
+	if backup.Spec.Image != "" {
+		dump.Image = backup.Spec.Image
+	}
+
+	upload := v1.Container{
+		Name:         "upload",
+		Image:        defaultS3Image,
+		Command:      []string{"/bin/sh", "-c", s3UploadScript},
+		Env:          append(s3Env(backup.Spec.Storage.S3), v1.EnvVar{Name: "S3_URL", Value: backupURL(backup.Spec.Storage.S3, backup, engine)}),
+		VolumeMounts: []v1.VolumeMount{{Name: "backup", MountPath: "/backup"}},
+	}
+
+	backoffLimit := int32(2)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: backup.Namespace,
+			Name:      backupJobName(backup),
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "databaser"},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					RestartPolicy:  v1.RestartPolicyNever,
+					InitContainers: []v1.Container{dump},
+					Containers:     []v1.Container{upload},
+					Volumes: []v1.Volume{{
+						Name:         "backup",
+						VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func deleteArtifactJobName(backup *databaserv1alpha1.DatabaseBackup) string {
+	return jobName(backup.Name, "delete")
+}
+
+// newDeleteArtifactJob creates a job deleting the uploaded backup from the storage
+func newDeleteArtifactJob(backup *databaserv1alpha1.DatabaseBackup) *batchv1.Job {
+	backoffLimit := int32(2)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: backup.Namespace,
+			Name:      deleteArtifactJobName(backup),
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "databaser"},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Name:    "delete",
+						Image:   defaultS3Image,
+						Command: []string{"/bin/sh", "-c", s3DeleteScript},
+						Env:     append(s3Env(backup.Spec.Storage.S3), v1.EnvVar{Name: "S3_URL", Value: backup.Status.Location}),
+					}},
+				},
+			},
+		},
+	}
+}
+
+func restoreJobName(restore *databaserv1alpha1.DatabaseRestore) string {
+	return jobName(restore.Name, "restore")
+}
+
+// newRestoreJob creates a job downloading the backup from the storage and restoring it with the credentials of the database
+func newRestoreJob(restore *databaserv1alpha1.DatabaseRestore, db *databaserv1alpha1.Database, engine string, source restoreSource) *batchv1.Job {
+	download := v1.Container{
+		Name:    "download",
+		Image:   defaultS3Image,
+		Command: []string{"/bin/sh", "-c", s3DownloadScript},
+		Env: append(s3Env(source.storage),
+			v1.EnvVar{Name: "S3_URL", Value: source.url},
+			v1.EnvVar{Name: "CHECKSUM", Value: source.checksum},
+		),
+		VolumeMounts: []v1.VolumeMount{{Name: "backup", MountPath: "/backup"}},
+	}
+
+	restoreContainer := v1.Container{
+		Name:         "restore",
+		Env:          databaseEnv(db),
+		VolumeMounts: []v1.VolumeMount{{Name: "backup", MountPath: "/backup"}},
+	}
+	if engine == "clickhouse" {
+		restoreContainer.Image = defaultClickhouseImage
+		restoreContainer.Command = []string{"/bin/sh", "-c", clickhouseRestoreScript}
+	} else {
+		restoreContainer.Image = defaultPostgresImage
+		restoreContainer.Command = []string{"/bin/sh", "-c", postgresRestoreScript}
+	}
+	if restore.Spec.Image != "" {
+		restoreContainer.Image = restore.Spec.Image
+	}
+
+	backoffLimit := int32(2)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: restore.Namespace,
+			Name:      restoreJobName(restore),
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "databaser"},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					RestartPolicy:  v1.RestartPolicyNever,
+					InitContainers: []v1.Container{download},
+					Containers:     []v1.Container{restoreContainer},
+					Volumes: []v1.Volume{{
+						Name:         "backup",
+						VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func migrationJobName(migration *databaserv1alpha1.DatabaseMigration) string {
+	return jobName(migration.Name, fmt.Sprintf("migrate-%d", migration.Generation))
+}
+
+// newMigrationJob creates a job copying the migrations out of the source image and applying them with the
+// migrate command of the operator image, using the credentials of the database
+func newMigrationJob(migration *databaserv1alpha1.DatabaseMigration, db *databaserv1alpha1.Database, engine string, operatorImage string) *batchv1.Job {
+	source := migration.Spec.Source.Image
+	copyContainer := v1.Container{
+		Name:         "copy",
+		Image:        source.Image,
+		Command:      []string{"/bin/sh", "-c", copyMigrationsScript},
+		Env:          []v1.EnvVar{{Name: "MIGRATIONS_PATH", Value: source.Path}},
+		VolumeMounts: []v1.VolumeMount{{Name: "migrations", MountPath: "/migrations"}},
+	}
+	migrateContainer := v1.Container{
+		Name:         "migrate",
+		Image:        operatorImage,
+		Command:      []string{"/manager", "migrate", "--dialect", engine, "--dir", "/migrations", "--table", migration.Spec.TrackingTable},
+		Env:          databaseEnv(db),
+		VolumeMounts: []v1.VolumeMount{{Name: "migrations", MountPath: "/migrations"}},
+	}
+
+	// a failed migration is not retried, it blocks until the spec changes
+	backoffLimit := int32(0)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: migration.Namespace,
+			Name:      migrationJobName(migration),
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "databaser"},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					RestartPolicy:  v1.RestartPolicyNever,
+					InitContainers: []v1.Container{copyContainer},
+					Containers:     []v1.Container{migrateContainer},
+					Volumes: []v1.Volume{{
+						Name:         "migrations",
+						VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// databaseEnv exposes the credentials secret of the database to a container
+func databaseEnv(db *databaserv1alpha1.Database) []v1.EnvVar {
+	ref := func(key string) *v1.EnvVarSource {
+		return &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: secretName(db)},
+			Key:                  key,
+		}}
+	}
+	return []v1.EnvVar{
+		{Name: "DB_HOST", ValueFrom: ref("host")},
+		{Name: "DB_PORT", ValueFrom: ref("port")},
+		{Name: "DB_NAME", ValueFrom: ref("database")},
+		{Name: "DB_USER", ValueFrom: ref("username")},
+		{Name: "DB_PASSWORD", ValueFrom: ref("password")},
+		{Name: "PGHOST", Value: "$(DB_HOST)"},
+		{Name: "PGPORT", Value: "$(DB_PORT)"},
+		{Name: "PGUSER", Value: "$(DB_USER)"},
+		{Name: "PGPASSWORD", Value: "$(DB_PASSWORD)"},
+	}
+}
+
+func s3Env(storage databaserv1alpha1.S3Storage) []v1.EnvVar {
+	ref := func(key string) *v1.EnvVarSource {
+		return &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: storage.CredentialsSecretName},
+			Key:                  key,
+		}}
+	}
+	region := storage.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return []v1.EnvVar{
+		{Name: "AWS_ACCESS_KEY_ID", ValueFrom: ref("accessKeyId")},
+		{Name: "AWS_SECRET_ACCESS_KEY", ValueFrom: ref("secretAccessKey")},
+		{Name: "AWS_DEFAULT_REGION", Value: region},
+		{Name: "S3_ENDPOINT", Value: storage.Endpoint},
+	}
+}
+
+// jobFinished tells whether the job is complete or failed for good
+func jobFinished(job *batchv1.Job) (bool, batchv1.JobConditionType) {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == v1.ConditionTrue {
+			return true, c.Type
+		}
+	}
+	return false, ""
+}
+
+// jobFinishedAt returns the time the job completed or failed
+func jobFinishedAt(job *batchv1.Job) time.Time {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == v1.ConditionTrue {
+			return c.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
+
+// getJobResult reads the result reported by the container of a succeeded job pod
+func getJobResult(ctx context.Context, c client.Client, job *batchv1.Job, container string) (jobResult, error) {
+	pods := &v1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return jobResult{}, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != v1.PodSucceeded {
+			continue
+		}
+		for _, s := range pod.Status.ContainerStatuses {
+			if s.Name != container || s.State.Terminated == nil {
+				continue
+			}
+			result := jobResult{}
+			if err := json.Unmarshal([]byte(s.State.Terminated.Message), &result); err != nil {
+				return jobResult{}, fmt.Errorf("failed to parse result of job %s; %w", job.Name, err)
+			}
+			return result, nil
+		}
+	}
+	return jobResult{}, fmt.Errorf("no result is reported by job %s", job.Name)
+}
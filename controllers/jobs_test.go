@@ -0,0 +1,48 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestJobName(t *testing.T) {
+	if got := jobName("orders", "backup"); got != "orders-backup" {
+		t.Errorf("got %q, want orders-backup", got)
+	}
+	long := strings.Repeat("a", 60)
+	got := jobName(long, "backup")
+	if len(got) > validation.LabelValueMaxLength || !strings.HasSuffix(got, "-backup") || !strings.HasPrefix(got, "aaaa") {
+		t.Errorf("got %q", got)
+	}
+	if errs := validation.IsDNS1123Label(got); len(errs) > 0 {
+		t.Errorf("%s: %v", got, errs)
+	}
+	if other := jobName(long+"b", "backup"); other == got {
+		t.Errorf("%s is generated for different names", got)
+	}
+	if other := jobName(long, "delete"); strings.TrimSuffix(other, "-delete") == strings.TrimSuffix(got, "-backup") {
+		t.Errorf("%s and %s share the hash", got, other)
+	}
+	dotted := jobName(strings.Repeat("a", 46)+"."+strings.Repeat("b", 20), "backup")
+	if len(dotted) > validation.LabelValueMaxLength || strings.Contains(dotted, ".-") {
+		t.Errorf("got %q", dotted)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// refersTo tells whether the database is provisioned on the instance
+func refersTo(db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance) bool {
+	ref := db.Spec.DatabaseInstanceRef
+	if ref.Name != instance.GetName() {
+		return false
+	}
+	if instance.GetNamespace() == "" {
+		return ref.Kind == "ClusterDatabaseInstance"
+	}
+	return ref.Kind != "ClusterDatabaseInstance" && db.Namespace == instance.GetNamespace()
+}
+
+// databaseCountChanged passes the database events changing the counts of the instance, updates are
+// left to the periodic reconcile of the instance
+var databaseCountChanged = predicate.Funcs{
+	UpdateFunc: func(event.UpdateEvent) bool {
+		return false
+	},
+	GenericFunc: func(event.GenericEvent) bool {
+		return false
+	},
+}
+
+// instanceRequest maps a database to the reconcile request of the instance it refers to
+func instanceRequest(clusterScoped bool) func(o client.Object) []reconcile.Request {
+	return func(o client.Object) []reconcile.Request {
+		db, ok := o.(*databaserv1alpha1.Database)
+		if !ok || (db.Spec.DatabaseInstanceRef.Kind == "ClusterDatabaseInstance") != clusterScoped {
+			return nil
+		}
+		key := client.ObjectKey{Namespace: db.Namespace, Name: db.Spec.DatabaseInstanceRef.Name}
+		if clusterScoped {
+			key.Namespace = ""
+		}
+		return []reconcile.Request{{NamespacedName: key}}
+	}
+}
+
+// listDatabases returns the databases referring to the instance
+func listDatabases(ctx context.Context, c client.Client, instance databaserv1alpha1.GenericDatabaseInstance) ([]databaserv1alpha1.Database, error) {
+	list := &databaserv1alpha1.DatabaseList{}
+	var opts []client.ListOption
+	if instance.GetNamespace() != "" {
+		opts = append(opts, client.InNamespace(instance.GetNamespace()))
+	}
+	if err := c.List(ctx, list, opts...); err != nil {
+		return nil, err
+	}
+	var dbs []databaserv1alpha1.Database
+	for _, db := range list.Items {
+		if refersTo(&db, instance) {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs, nil
+}
+
+// countDatabases counts the databases provisioned on the instance, in total and by namespace, the except
+// database is left out so that the controller and the webhook apply the limits with the same counts
+func countDatabases(ctx context.Context, c client.Client, instance databaserv1alpha1.GenericDatabaseInstance, except *databaserv1alpha1.Database) (int, map[string]int, error) {
+	dbs, err := listDatabases(ctx, c, instance)
+	if err != nil {
+		return 0, nil, err
+	}
+	total := 0
+	perNamespace := map[string]int{}
+	for i := range dbs {
+		db := &dbs[i]
+		if !isProvisioned(db) {
+			continue
+		}
+		if except != nil && db.Namespace == except.Namespace && db.Name == except.Name {
+			continue
+		}
+		total++
+		perNamespace[db.Namespace]++
+	}
+	return total, perNamespace, nil
+}
+
+// isProvisioned tells whether the database was admitted to the instance by the controller
+func isProvisioned(db *databaserv1alpha1.Database) bool {
+	return controllerutil.ContainsFinalizer(db, databaseFinalizer)
+}
+
+// databaseUsers lists the users the controller creates for the database
+func databaseUsers(db *databaserv1alpha1.Database) []string {
+	return []string{dbName(db)}
+}
+
+// accessUsers lists the users granted access to the database, its own user and the owners and readers of its schemas
+func accessUsers(db *databaserv1alpha1.Database) []string {
+	users := databaseUsers(db)
+	seen := map[string]bool{}
+	for _, user := range users {
+		seen[user] = true
+	}
+	for _, schema := range db.Spec.Schemas {
+		for _, user := range append([]string{schema.Owner}, schema.ReadOnlyUsers...) {
+			if user != "" && !seen[user] {
+				seen[user] = true
+				users = append(users, user)
+			}
+		}
+	}
+	return users
+}
+
+// countUsers counts the users granted access to each database provisioned on the instance, by namespace/name
+func countUsers(ctx context.Context, c client.Client, instance databaserv1alpha1.GenericDatabaseInstance) (map[string]int, error) {
+	dbs, err := listDatabases(ctx, c, instance)
+	if err != nil {
+		return nil, err
+	}
+	users := map[string]int{}
+	for i := range dbs {
+		if isProvisioned(&dbs[i]) {
+			users[client.ObjectKeyFromObject(&dbs[i]).String()] = len(accessUsers(&dbs[i]))
+		}
+	}
+	return users, nil
+}
+
+// checkUserLimit validates the users granted access to the database against the instance limits, unlike the
+// database counts it applies to provisioned databases too since schemas can grant access to more users
+func checkUserLimit(limits *databaserv1alpha1.InstanceLimits, db *databaserv1alpha1.Database) error {
+	if limits == nil || limits.MaxUsersPerDatabase == 0 {
+		return nil
+	}
+	if users := accessUsers(db); len(users) > limits.MaxUsersPerDatabase {
+		return fmt.Errorf("database grants access to %d users, instance allows %d", len(users), limits.MaxUsersPerDatabase)
+	}
+	return nil
+}
+
+// checkLimits validates the database against the instance limits, the counts should not include the database itself
+func checkLimits(limits *databaserv1alpha1.InstanceLimits, db *databaserv1alpha1.Database, total int, perNamespace map[string]int) error {
+	if limits == nil {
+		return nil
+	}
+	if err := checkUserLimit(limits, db); err != nil {
+		return err
+	}
+	if limits.MaxDatabases > 0 && total >= limits.MaxDatabases {
+		return fmt.Errorf("instance limit of %d databases is reached", limits.MaxDatabases)
+	}
+	if limits.MaxDatabasesPerNamespace > 0 && perNamespace[db.Namespace] >= limits.MaxDatabasesPerNamespace {
+		return fmt.Errorf("instance limit of %d databases in namespace %s is reached", limits.MaxDatabasesPerNamespace, db.Namespace)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+func TestCheckLimits(t *testing.T) {
+	for name, tc := range map[string]struct {
+		limits       *databaserv1alpha1.InstanceLimits
+		schemas      []databaserv1alpha1.Schema
+		total        int
+		perNamespace map[string]int
+		wantErr      bool
+	}{
+		"no limits": {
+			total: 100,
+		},
+		"unlimited": {
+			limits: &databaserv1alpha1.InstanceLimits{},
+			total:  100,
+		},
+		"below total": {
+			limits: &databaserv1alpha1.InstanceLimits{MaxDatabases: 2},
+			total:  1,
+		},
+		"total reached": {
+			limits:  &databaserv1alpha1.InstanceLimits{MaxDatabases: 2},
+			total:   2,
+			wantErr: true,
+		},
+		"below namespace": {
+			limits:       &databaserv1alpha1.InstanceLimits{MaxDatabasesPerNamespace: 1},
+			total:        5,
+			perNamespace: map[string]int{"other": 5},
+		},
+		"namespace reached": {
+			limits:       &databaserv1alpha1.InstanceLimits{MaxDatabasesPerNamespace: 1},
+			total:        1,
+			perNamespace: map[string]int{"shop": 1},
+			wantErr:      true,
+		},
+		"own user within users": {
+			limits: &databaserv1alpha1.InstanceLimits{MaxUsersPerDatabase: 1},
+			schemas: []databaserv1alpha1.Schema{
+				{Name: "sales", ReadOnlyUsers: []string{"orders"}},
+			},
+		},
+		"users exceeded": {
+			limits: &databaserv1alpha1.InstanceLimits{MaxUsersPerDatabase: 2},
+			schemas: []databaserv1alpha1.Schema{
+				{Name: "sales", Owner: "sales_owner", ReadOnlyUsers: []string{"analyst"}},
+				{Name: "audit", ReadOnlyUsers: []string{"analyst"}},
+			},
+			wantErr: true,
+		},
+	} {
+		db := &databaserv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Spec:       databaserv1alpha1.DatabaseSpec{Schemas: tc.schemas},
+		}
+		err := checkLimits(tc.limits, db, tc.total, tc.perNamespace)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCountDatabases(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := databaserv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	database := func(namespace string, name string, instance string, provisioned bool) *databaserv1alpha1.Database {
+		db := &databaserv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: databaserv1alpha1.DatabaseSpec{
+				DatabaseInstanceRef: databaserv1alpha1.DatabaseInstanceRef{Kind: "ClusterDatabaseInstance", Name: instance},
+			},
+		}
+		if provisioned {
+			db.Finalizers = []string{databaseFinalizer}
+		}
+		return db
+	}
+	self := database("shop", "orders", "main", true)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		self,
+		database("shop", "users", "main", true),
+		database("blog", "posts", "main", true),
+		database("blog", "drafts", "main", false),
+		database("shop", "audit", "other", true),
+	).Build()
+	instance := &databaserv1alpha1.ClusterDatabaseInstance{ObjectMeta: metav1.ObjectMeta{Name: "main"}}
+
+	total, perNamespace, err := countDatabases(context.Background(), c, instance, self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || perNamespace["shop"] != 1 || perNamespace["blog"] != 1 {
+		t.Errorf("got %d databases, %v by namespace", total, perNamespace)
+	}
+	total, _, err = countDatabases(context.Background(), c, instance, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 {
+		t.Errorf("got %d databases without exception, want 3", total)
+	}
+}
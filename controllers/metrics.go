@@ -0,0 +1,41 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	databaseSizeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "databaser_database_size_bytes",
+		Help: "Size of the database on the instance in bytes",
+	}, []string{"namespace", "name"})
+	databaseTables = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "databaser_database_tables",
+		Help: "Number of tables in the database",
+	}, []string{"namespace", "name"})
+	databaseConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "databaser_database_connections",
+		Help: "Number of active connections to the database",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(databaseSizeBytes, databaseTables, databaseConnections)
+}
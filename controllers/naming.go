@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/slamdev/databaser/pkg/clickhouse"
+	"github.com/slamdev/databaser/pkg/postgres"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"text/template"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+const defaultDatabaseNameTemplate = "{{ .Name }}"
+
+// dbName is the name of the database and its user on the instance. Databases provisioned
+// before the name was recorded in the status keep the name of the object.
+func dbName(db *databaserv1alpha1.Database) string {
+	if db.Status.DatabaseName != "" {
+		return db.Status.DatabaseName
+	}
+	return db.Name
+}
+
+// resolveDatabaseName returns the explicit name of the database or renders the template of the instance
+func resolveDatabaseName(db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance) (string, error) {
+	engine := instanceEngine(instance)
+	if name := db.Spec.DatabaseName; name != "" {
+		if err := validateDatabaseName(engine, name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+
+	text := instance.GetSpec().DatabaseNameTemplate
+	if text == "" {
+		text = defaultDatabaseNameTemplate
+	}
+	tmpl, err := template.New("databaseName").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse database name template; %w", err)
+	}
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, db); err != nil {
+		return "", fmt.Errorf("failed to render database name template; %w", err)
+	}
+	if engine == "clickhouse" {
+		return clickhouse.SanitizeIdentifier(buf.String()), nil
+	}
+	return postgres.SanitizeIdentifier(buf.String()), nil
+}
+
+// validateDatabaseName accepts the explicit names that are identifiers of the engine needing no quoting,
+// which are the names a template renders to
+func validateDatabaseName(engine string, name string) error {
+	if engine == "clickhouse" {
+		if name != clickhouse.SanitizeIdentifier(name) {
+			return fmt.Errorf("database name %s should contain only letters, digits and underscores and not start with a digit", name)
+		}
+		return nil
+	}
+	if len(name) > postgres.MaxIdentifierLength {
+		return fmt.Errorf("database name %s is longer than %d bytes", name, postgres.MaxIdentifierLength)
+	}
+	if name != postgres.SanitizeIdentifier(name) {
+		return fmt.Errorf("database name %s should contain only lower case letters, digits and underscores and not start with a digit", name)
+	}
+	return nil
+}
+
+// reservedNames are the predefined databases and users of the engines and of the managed services, the
+// operator never provisions nor adopts a database and a user named like one of them.
+var reservedNames = map[string][]string{
+	"postgres":   {"postgres", "template0", "template1", "public", "rdsadmin", "rds_superuser", "cloudsqladmin", "cloudsqlsuperuser", "azure_superuser", "azure_pg_admin", "azure_maintenance", "azure_sys"},
+	"clickhouse": {"default", "system", "information_schema", "INFORMATION_SCHEMA"},
+}
+
+// checkReservedName refuses the names of the admin user and database and the reserved names of the engine
+func checkReservedName(instance databaserv1alpha1.GenericDatabaseInstance, admin []string, name string) error {
+	for _, a := range admin {
+		if name == a {
+			return fmt.Errorf("name %s is used by the admin of the instance", name)
+		}
+	}
+	engine := instanceEngine(instance)
+	if engine == "postgres" && strings.HasPrefix(name, "pg_") {
+		return fmt.Errorf("name %s is reserved by postgres", name)
+	}
+	for _, reserved := range reservedNames[engine] {
+		if name == reserved {
+			return fmt.Errorf("name %s is reserved by %s", name, engine)
+		}
+	}
+	return nil
+}
+
+// findNameCollision returns another database on the instance with the same name
+func findNameCollision(ctx context.Context, c client.Client, db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance, name string) (*databaserv1alpha1.Database, error) {
+	dbs, err := listDatabases(ctx, c, instance)
+	if err != nil {
+		return nil, err
+	}
+	for i := range dbs {
+		o := &dbs[i]
+		if o.UID != db.UID && isProvisioned(o) && dbName(o) == name {
+			return o, nil
+		}
+	}
+	return nil, nil
+}
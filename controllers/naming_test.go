@@ -0,0 +1,114 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+func TestResolveDatabaseName(t *testing.T) {
+	postgresInstance := func(template string) databaserv1alpha1.GenericDatabaseInstance {
+		return &databaserv1alpha1.DatabaseInstance{Spec: databaserv1alpha1.DatabaseInstanceSpec{
+			Postgres:             &databaserv1alpha1.PostgresSpec{},
+			DatabaseNameTemplate: template,
+		}}
+	}
+	clickhouseInstance := &databaserv1alpha1.DatabaseInstance{Spec: databaserv1alpha1.DatabaseInstanceSpec{
+		Clikhouse: &databaserv1alpha1.ClikhouseSpec{},
+	}}
+	for name, tc := range map[string]struct {
+		instance     databaserv1alpha1.GenericDatabaseInstance
+		databaseName string
+		want         string
+		wantErr      bool
+	}{
+		"default template": {
+			instance: postgresInstance(""),
+			want:     "orders_api",
+		},
+		"namespace template": {
+			instance: postgresInstance("{{ .Namespace }}_{{ .Name }}"),
+			want:     "shop_orders_api",
+		},
+		"clickhouse keeps case": {
+			instance: clickhouseInstance,
+			want:     "Orders_api",
+		},
+		"unknown field": {
+			instance: postgresInstance("{{ .Team }}"),
+			wantErr:  true,
+		},
+		"explicit": {
+			instance:     postgresInstance("{{ .Namespace }}_{{ .Name }}"),
+			databaseName: "orders",
+			want:         "orders",
+		},
+		"explicit too long": {
+			instance:     postgresInstance(""),
+			databaseName: strings.Repeat("a", 64),
+			wantErr:      true,
+		},
+		"explicit needs quoting": {
+			instance:     postgresInstance(""),
+			databaseName: "Orders-DB",
+			wantErr:      true,
+		},
+		"explicit upper case in clickhouse": {
+			instance:     clickhouseInstance,
+			databaseName: "Orders",
+			want:         "Orders",
+		},
+		"explicit starts with digit": {
+			instance:     clickhouseInstance,
+			databaseName: "1orders",
+			wantErr:      true,
+		},
+	} {
+		db := &databaserv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: "Orders-api", Namespace: "shop"},
+			Spec:       databaserv1alpha1.DatabaseSpec{DatabaseName: tc.databaseName},
+		}
+		got, err := resolveDatabaseName(db, tc.instance)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", name, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", name, got, tc.want)
+		}
+	}
+}
+
+func TestCheckReservedName(t *testing.T) {
+	instance := &databaserv1alpha1.DatabaseInstance{Spec: databaserv1alpha1.DatabaseInstanceSpec{
+		Postgres: &databaserv1alpha1.PostgresSpec{},
+	}}
+	admin := []string{"admin", "appdb"}
+	for _, name := range []string{"admin", "appdb", "postgres", "template1", "rdsadmin", "pg_monitor"} {
+		if err := checkReservedName(instance, admin, name); err == nil {
+			t.Errorf("%s is accepted", name)
+		}
+	}
+	if err := checkReservedName(instance, admin, "orders"); err != nil {
+		t.Error(err)
+	}
+}
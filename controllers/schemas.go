@@ -0,0 +1,113 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"github.com/slamdev/databaser/pkg"
+	v1 "k8s.io/api/core/v1"
+	"reflect"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// syncSchemas provisions the schemas of the spec and handles the ones removed since the last sync,
+// the status keeps the schemas as they are provisioned to know what is removed.
+func (r *DatabaseReconciler) syncSchemas(ctx context.Context, db *databaserv1alpha1.Database, s pkg.Server) error {
+	user := dbName(db)
+	var schemas []databaserv1alpha1.Schema
+	for _, schema := range db.Spec.Schemas {
+		if schema.Owner == "" {
+			schema.Owner = user
+		}
+		schemas = append(schemas, schema)
+	}
+	if len(schemas) == 0 && len(db.Status.Schemas) == 0 {
+		return nil
+	}
+
+	for _, old := range db.Status.Schemas {
+		schema := findSchema(schemas, old.Name)
+		if schema == nil {
+			if db.Spec.SchemaRemoval == databaserv1alpha1.SchemaRemovalDrop {
+				if err := s.DropSchema(ctx, dbName(db), old.Name); err != nil {
+					return err
+				}
+				r.Recorder.Eventf(db, v1.EventTypeNormal, "SchemaDropped", "schema %s is dropped", old.Name)
+			}
+			continue
+		}
+		revoked := pkg.Schema{Name: old.Name, Owner: old.Owner, Readers: missing(old.ReadOnlyUsers, schema.ReadOnlyUsers)}
+		if len(revoked.Readers) > 0 {
+			if err := s.RevokeSchemaReaders(ctx, dbName(db), revoked, user); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, schema := range schemas {
+		if err := s.EnsureSchema(ctx, dbName(db), pkg.Schema{Name: schema.Name, Owner: schema.Owner, Readers: schema.ReadOnlyUsers}, user); err != nil {
+			return err
+		}
+		if findSchema(db.Status.Schemas, schema.Name) == nil {
+			r.Recorder.Eventf(db, v1.EventTypeNormal, "SchemaCreated", "schema %s is created", schema.Name)
+		}
+	}
+
+	if names := schemaNames(schemas); !reflect.DeepEqual(names, schemaNames(db.Status.Schemas)) {
+		if err := s.SetSearchPath(ctx, dbName(db), user, names); err != nil {
+			return err
+		}
+	}
+	db.Status.Schemas = schemas
+	return nil
+}
+
+func findSchema(schemas []databaserv1alpha1.Schema, name string) *databaserv1alpha1.Schema {
+	for i := range schemas {
+		if schemas[i].Name == name {
+			return &schemas[i]
+		}
+	}
+	return nil
+}
+
+func schemaNames(schemas []databaserv1alpha1.Schema) []string {
+	var names []string
+	for _, schema := range schemas {
+		names = append(names, schema.Name)
+	}
+	return names
+}
+
+// missing returns the items of the old list which are not in the current one
+func missing(old []string, current []string) []string {
+	var items []string
+	for _, item := range old {
+		found := false
+		for _, n := range current {
+			if n == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			items = append(items, item)
+		}
+	}
+	return items
+}
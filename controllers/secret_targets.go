@@ -0,0 +1,135 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// copies can't be owned by a database from another namespace, so they are tracked by labels
+const (
+	secretSourceNamespaceLabel = "databaser.slamdev.github.com/source-namespace"
+	secretSourceNameLabel      = "databaser.slamdev.github.com/source-name"
+)
+
+// syncSecretTargets copies the secret of the database to its targets and removes copies that are no longer targeted
+func (r *DatabaseReconciler) syncSecretTargets(ctx context.Context, db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance) error {
+	if db.Spec.Vault != nil && db.Spec.Vault.SkipSecret && len(db.Spec.SecretTargets) > 0 {
+		return fmt.Errorf("secret targets can't be copied since the secret is skipped in favor of vault")
+	}
+	if err := checkSecretTargets(db, instance); err != nil {
+		return err
+	}
+
+	source := &v1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: db.Namespace, Name: secretName(db)}, source); err != nil {
+		return err
+	}
+	keep := map[client.ObjectKey]bool{}
+	for _, target := range db.Spec.SecretTargets {
+		key := client.ObjectKey{Namespace: target.Namespace, Name: target.Name}
+		if key.Name == "" {
+			key.Name = source.Name
+		}
+		keep[key] = true
+		if err := r.copySecret(ctx, db, source, key); err != nil {
+			return err
+		}
+	}
+	return r.deleteSecretTargets(ctx, db, keep)
+}
+
+// checkSecretTargets confines the targets to the namespace of a DatabaseInstance, which can't grant access
+// to other namespaces, and to the allowed namespaces of a ClusterDatabaseInstance
+func checkSecretTargets(db *databaserv1alpha1.Database, instance databaserv1alpha1.GenericDatabaseInstance) error {
+	if instance.GetNamespace() != "" {
+		for _, target := range db.Spec.SecretTargets {
+			if target.Namespace != instance.GetNamespace() {
+				return fmt.Errorf("secret targets of instance %s/%s are confined to its namespace, %s is not allowed", instance.GetNamespace(), instance.GetName(), target.Namespace)
+			}
+		}
+		return nil
+	}
+	allowed := map[string]bool{}
+	for _, ns := range instance.GetSpec().SecretTargetNamespaces {
+		allowed[ns] = true
+	}
+	for _, target := range db.Spec.SecretTargets {
+		if !allowed[target.Namespace] {
+			return fmt.Errorf("instance doesn't allow secret targets in namespace %s", target.Namespace)
+		}
+	}
+	return nil
+}
+
+func (r *DatabaseReconciler) copySecret(ctx context.Context, db *databaserv1alpha1.Database, source *v1.Secret, key client.ObjectKey) error {
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}
+	if err := r.Client.Get(ctx, key, secret); err == nil {
+		if !isSecretCopy(secret, db) {
+			return fmt.Errorf("secret %s/%s is not managed by the database", key.Namespace, key.Name)
+		}
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels[secretSourceNamespaceLabel] = db.Namespace
+		secret.Labels[secretSourceNameLabel] = db.Name
+		secret.Data = source.Data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if op == controllerutil.OperationResultCreated {
+		r.Recorder.Eventf(db, v1.EventTypeNormal, "SecretCopied", "secret is copied to %s/%s", key.Namespace, key.Name)
+	}
+	return nil
+}
+
+// deleteSecretTargets removes the copies of the secret except the kept ones
+func (r *DatabaseReconciler) deleteSecretTargets(ctx context.Context, db *databaserv1alpha1.Database, keep map[client.ObjectKey]bool) error {
+	list := &v1.SecretList{}
+	if err := r.Client.List(ctx, list, client.MatchingLabels{secretSourceNamespaceLabel: db.Namespace, secretSourceNameLabel: db.Name}); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		secret := &list.Items[i]
+		if keep[client.ObjectKeyFromObject(secret)] {
+			continue
+		}
+		if err := r.Client.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(db, v1.EventTypeNormal, "SecretCopyDeleted", "secret copy %s/%s is deleted", secret.Namespace, secret.Name)
+	}
+	return nil
+}
+
+func isSecretCopy(secret *v1.Secret, db *databaserv1alpha1.Database) bool {
+	return secret.Labels[secretSourceNamespaceLabel] == db.Namespace && secret.Labels[secretSourceNameLabel] == db.Name
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+func TestCheckSecretTargets(t *testing.T) {
+	namespaced := &databaserv1alpha1.DatabaseInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: "main", Namespace: "shop"},
+		Spec:       databaserv1alpha1.DatabaseInstanceSpec{SecretTargetNamespaces: []string{"billing"}},
+	}
+	cluster := &databaserv1alpha1.ClusterDatabaseInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: "main"},
+		Spec: databaserv1alpha1.ClusterDatabaseInstanceSpec{
+			DatabaseInstanceSpec: databaserv1alpha1.DatabaseInstanceSpec{SecretTargetNamespaces: []string{"billing"}},
+		},
+	}
+	for name, tc := range map[string]struct {
+		instance databaserv1alpha1.GenericDatabaseInstance
+		targets  []string
+		wantErr  bool
+	}{
+		"namespaced without targets": {
+			instance: namespaced,
+		},
+		"namespaced in own namespace": {
+			instance: namespaced,
+			targets:  []string{"shop"},
+		},
+		"namespaced ignores allow-list": {
+			instance: namespaced,
+			targets:  []string{"billing"},
+			wantErr:  true,
+		},
+		"namespaced in other namespace": {
+			instance: namespaced,
+			targets:  []string{"shop", "kube-system"},
+			wantErr:  true,
+		},
+		"cluster in allowed namespace": {
+			instance: cluster,
+			targets:  []string{"billing"},
+		},
+		"cluster in other namespace": {
+			instance: cluster,
+			targets:  []string{"kube-system"},
+			wantErr:  true,
+		},
+	} {
+		db := &databaserv1alpha1.Database{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"}}
+		for _, ns := range tc.targets {
+			db.Spec.SecretTargets = append(db.Spec.SecretTargets, databaserv1alpha1.SecretTarget{Namespace: ns})
+		}
+		err := checkSecretTargets(db, tc.instance)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", name, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"text/template"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// secretTemplateData is available to the templates of the secret
+type secretTemplateData struct {
+	Host     string
+	Port     int
+	Database string
+	Username string
+	Password string
+	TLS      secretTemplateTLS
+}
+
+type secretTemplateTLS struct {
+	CA   string
+	Cert string
+	Key  string
+}
+
+// renderSecretTemplate renders every template of the database secret
+func renderSecretTemplate(ctx context.Context, c client.Client, db *databaserv1alpha1.Database, data secretTemplateData) (map[string][]byte, error) {
+	spec := db.Spec.SecretTemplate
+	if spec.TLSSecretName != "" {
+		tls := &v1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: db.Namespace, Name: spec.TLSSecretName}, tls); err != nil {
+			return nil, fmt.Errorf("failed to get tls secret %s; %w", spec.TLSSecretName, err)
+		}
+		data.TLS = secretTemplateTLS{
+			CA:   string(tls.Data["ca.crt"]),
+			Cert: string(tls.Data["tls.crt"]),
+			Key:  string(tls.Data["tls.key"]),
+		}
+	}
+
+	rendered := map[string][]byte{}
+	for key, text := range spec.Data {
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse secret template %s; %w", key, err)
+		}
+		buf := bytes.Buffer{}
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render secret template %s; %w", key, err)
+		}
+		rendered[key] = buf.Bytes()
+	}
+	return rendered, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/slamdev/databaser/pkg/vault"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"path"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+// vaultPath is where the credentials of the database are kept, confined to its namespace
+func vaultPath(db *databaserv1alpha1.Database) (string, error) {
+	p := db.Spec.Vault.Path
+	if p == "" {
+		p = db.Name
+	}
+	p = path.Join(db.Namespace, path.Clean("/"+p))
+	if p == db.Namespace {
+		return "", fmt.Errorf("vault path %s is invalid", db.Spec.Vault.Path)
+	}
+	return p, nil
+}
+
+// databasePassword reads the password of the database user from its secret or from vault
+func databasePassword(ctx context.Context, c client.Client, v *vault.Client, db *databaserv1alpha1.Database) (string, error) {
+	secret := &v1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: db.Namespace, Name: secretName(db)}, secret); err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if password := string(secret.Data["password"]); password != "" {
+		return password, nil
+	}
+	if db.Spec.Vault != nil {
+		return readVaultPassword(ctx, v, db)
+	}
+	return "", fmt.Errorf("password of database %s is not found", db.Name)
+}
+
+func readVaultPassword(ctx context.Context, v *vault.Client, db *databaserv1alpha1.Database) (string, error) {
+	if v == nil {
+		return "", fmt.Errorf("vault is not configured in the operator")
+	}
+	p, err := vaultPath(db)
+	if err != nil {
+		return "", err
+	}
+	data, err := v.Read(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	return data["password"], nil
+}
+
+// writeVaultCredentials writes the credentials when they differ from the stored ones, every write is a new version
+func (r *DatabaseReconciler) writeVaultCredentials(ctx context.Context, db *databaserv1alpha1.Database, data map[string][]byte) error {
+	if r.Vault == nil {
+		return fmt.Errorf("vault is not configured in the operator")
+	}
+	p, err := vaultPath(db)
+	if err != nil {
+		return err
+	}
+	credentials := map[string]string{}
+	for k, v := range data {
+		credentials[k] = string(v)
+	}
+	current, err := r.Vault.Read(ctx, p)
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(current, credentials) {
+		return nil
+	}
+	return r.Vault.Write(ctx, p, credentials)
+}
+
+func (r *DatabaseReconciler) deleteVaultCredentials(ctx context.Context, db *databaserv1alpha1.Database) error {
+	if r.Vault == nil {
+		return fmt.Errorf("vault is not configured in the operator")
+	}
+	p, err := vaultPath(db)
+	if err != nil {
+		return err
+	}
+	return r.Vault.Delete(ctx, p)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
+)
+
+func TestVaultPath(t *testing.T) {
+	for name, tc := range map[string]struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		"default":                {want: "shop/orders"},
+		"nested":                 {path: "apps/orders/primary", want: "shop/apps/orders/primary"},
+		"cleaned":                {path: "apps//orders/", want: "shop/apps/orders"},
+		"escape is confined":     {path: "../billing/orders", want: "shop/billing/orders"},
+		"absolute is confined":   {path: "/billing/orders", want: "shop/billing/orders"},
+		"namespace root":         {path: "/", wantErr: true},
+		"parent of the root":     {path: "..", wantErr: true},
+		"parent of a child path": {path: "apps/..", wantErr: true},
+	} {
+		db := &databaserv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Spec:       databaserv1alpha1.DatabaseSpec{Vault: &databaserv1alpha1.VaultCredentials{Path: tc.path}},
+		}
+		got, err := vaultPath(db)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", name, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", name, got, tc.want)
+		}
+	}
+}
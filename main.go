@@ -17,8 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -33,6 +40,12 @@ import (
 
 	databaserv1alpha1 "github.com/slamdev/databaser/api/v1alpha1"
 	"github.com/slamdev/databaser/controllers"
+	"github.com/slamdev/databaser/pkg"
+	"github.com/slamdev/databaser/pkg/clickhouse"
+	"github.com/slamdev/databaser/pkg/migrate"
+	"github.com/slamdev/databaser/pkg/postgres"
+	"github.com/slamdev/databaser/pkg/secrets"
+	"github.com/slamdev/databaser/pkg/vault"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -49,14 +62,40 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var secretsDir string
+	var operatorImage string
+	var cloudSQLTokenProvider bool
+	vaultClient := &vault.Client{Token: os.Getenv("VAULT_TOKEN")}
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&secretsDir, "secrets-dir", "",
+		"Directory with secrets readable by the file secret provider, e.g. mounted by the Secrets Store CSI driver. "+
+			"The provider is disabled when empty.")
+	flag.StringVar(&vaultClient.Address, "vault-address", "",
+		"Address of Vault used as the vault secret provider and to keep database credentials. "+
+			"Vault is disabled when empty, the VAULT_TOKEN environment variable replaces the Kubernetes login.")
+	flag.StringVar(&vaultClient.Role, "vault-role", "databaser", "Role of the Vault Kubernetes auth method.")
+	flag.StringVar(&vaultClient.AuthMount, "vault-auth-mount", "kubernetes", "Mount of the Vault Kubernetes auth method.")
+	flag.StringVar(&vaultClient.KVMount, "vault-kv-mount", "secret", "Mount of the Vault KV version 2 engine.")
+	flag.StringVar(&operatorImage, "operator-image", os.Getenv("OPERATOR_IMAGE"),
+		"Image of the operator, it runs the migrate command in the jobs of DatabaseMigrations with an image source.")
+	flag.BoolVar(&cloudSQLTokenProvider, "cloudsql-token-provider", false,
+		"Register the cloudsql token provider issuing tokens of the service account of the operator "+
+			"for the iamToken auth of Cloud SQL instances.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,6 +104,18 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if secretsDir != "" {
+		secrets.Register("file", &secrets.FileProvider{Dir: secretsDir})
+	}
+	if cloudSQLTokenProvider {
+		postgres.RegisterTokenProvider("cloudsql", &postgres.CloudSQLTokenProvider{})
+	}
+	if vaultClient.Address != "" {
+		secrets.Register("vault", vaultClient)
+	} else {
+		vaultClient = nil
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -79,21 +130,79 @@ func main() {
 	}
 
 	if err = (&controllers.DatabaseInstanceReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("DatabaseInstance"),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("DatabaseInstance"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("databaseinstance-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DatabaseInstance")
 		os.Exit(1)
 	}
+	if err = (&controllers.ClusterDatabaseInstanceReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("ClusterDatabaseInstance"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("clusterdatabaseinstance-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterDatabaseInstance")
+		os.Exit(1)
+	}
 	if err = (&controllers.DatabaseReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Database"),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Database"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("database-controller"),
+		Vault:    vaultClient,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Database")
 		os.Exit(1)
 	}
+	if err = (&controllers.DatabaseBackupReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("DatabaseBackup"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("databasebackup-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DatabaseBackup")
+		os.Exit(1)
+	}
+	if err = (&controllers.DatabaseBackupScheduleReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("DatabaseBackupSchedule"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("databasebackupschedule-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DatabaseBackupSchedule")
+		os.Exit(1)
+	}
+	if err = (&controllers.DatabaseRestoreReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("DatabaseRestore"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("databaserestore-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DatabaseRestore")
+		os.Exit(1)
+	}
+	if err = (&controllers.DatabaseMigrationReconciler{
+		Client:        mgr.GetClient(),
+		Log:           ctrl.Log.WithName("controllers").WithName("DatabaseMigration"),
+		Scheme:        mgr.GetScheme(),
+		Recorder:      mgr.GetEventRecorderFor("databasemigration-controller"),
+		Vault:         vaultClient,
+		OperatorImage: operatorImage,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DatabaseMigration")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&controllers.DatabaseValidator{
+			Client: mgr.GetClient(),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Database")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("health", healthz.Ping); err != nil {
@@ -111,3 +220,74 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// runMigrate applies the migrations of a directory with the credentials of the DB_* environment variables,
+// the applied version is reported in the termination message of the container.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dialect := fs.String("dialect", string(migrate.Postgres), "SQL dialect of the database, postgres or clickhouse.")
+	dir := fs.String("dir", "/migrations", "Directory with the migration files.")
+	table := fs.String("table", migrate.DefaultTable, "Table keeping the applied versions.")
+	terminationLog := fs.String("termination-log", "/dev/termination-log", "File the applied version is reported to.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(*dir)
+	if err != nil {
+		return fmt.Errorf("failed to read migrations; %w", err)
+	}
+	files := map[string]string{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(*dir, e.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read migration %s; %w", e.Name(), err)
+		}
+		files[e.Name()] = string(content)
+	}
+	migrations, err := migrate.Load(files)
+	if err != nil {
+		return err
+	}
+
+	port, err := strconv.Atoi(os.Getenv("DB_PORT"))
+	if err != nil {
+		return fmt.Errorf("failed to parse port; %w", err)
+	}
+	ctx := context.Background()
+	var db *sql.DB
+	if migrate.Dialect(*dialect) == migrate.Clickhouse {
+		db, err = pkg.CreateClickhouseSqlConnection(ctx, clickhouse.Params{
+			User:     os.Getenv("DB_USER"),
+			Password: os.Getenv("DB_PASSWORD"),
+			Host:     os.Getenv("DB_HOST"),
+			Port:     port,
+			Database: os.Getenv("DB_NAME"),
+		})
+	} else {
+		db, err = pkg.CreatePostgresSqlConnection(ctx, postgres.Params{
+			User:     os.Getenv("DB_USER"),
+			Password: os.Getenv("DB_PASSWORD"),
+			Host:     os.Getenv("DB_HOST"),
+			Port:     port,
+			AuthDB:   os.Getenv("DB_NAME"),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, err := migrate.Run(ctx, db, migrate.Dialect(*dialect), *table, migrations)
+	if err != nil {
+		return err
+	}
+	result, err := json.Marshal(map[string]string{"version": version})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*terminationLog, result, 0644)
+}
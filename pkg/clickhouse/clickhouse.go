@@ -3,7 +3,9 @@ package clickhouse
 import (
 	"fmt"
 	_ "github.com/ClickHouse/clickhouse-go"
+	"github.com/slamdev/databaser/pkg/tunnel"
 	"net/url"
+	"strings"
 )
 
 type Params struct {
@@ -11,6 +13,9 @@ type Params struct {
 	Password string
 	Host     string
 	Port     int
+	Database string
+	// Reaches the host through the bastion when set.
+	Tunnel *tunnel.Config
 }
 
 func DSN(params Params) (string, url.URL) {
@@ -21,6 +26,9 @@ func DSN(params Params) (string, url.URL) {
 	if params.Password != "" {
 		query.Set("password", params.Password)
 	}
+	if params.Database != "" {
+		query.Set("database", params.Database)
+	}
 	dbUrl := url.URL{
 		Scheme:   "tcp",
 		Host:     fmt.Sprintf("%s:%d", params.Host, params.Port),
@@ -28,3 +36,28 @@ func DSN(params Params) (string, url.URL) {
 	}
 	return "clickhouse", dbUrl
 }
+
+// SanitizeIdentifier turns the name into an identifier that needs no quoting
+func SanitizeIdentifier(name string) string {
+	b := strings.Builder{}
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	s := b.String()
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	return s
+}
+
+func QuoteIdentifier(name string) string {
+	return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(name) + "`"
+}
+
+func QuoteLiteral(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
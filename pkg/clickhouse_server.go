@@ -0,0 +1,249 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/slamdev/databaser/pkg/clickhouse"
+	"strings"
+)
+
+type clickhouseServer struct {
+	db     *sql.DB
+	params clickhouse.Params
+}
+
+func (s *clickhouseServer) DatabaseExists(ctx context.Context, name string) (bool, error) {
+	var count int64
+	if err := s.db.QueryRowContext(ctx, "SELECT count() FROM system.databases WHERE name = ?", name).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check database %s; %w", name, err)
+	}
+	return count > 0, nil
+}
+
+func (s *clickhouseServer) CreateDatabase(ctx context.Context, name string) error {
+	if _, err := s.db.ExecContext(ctx, "CREATE DATABASE "+clickhouse.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to create database %s; %w", name, err)
+	}
+	return nil
+}
+
+func (s *clickhouseServer) DropDatabase(ctx context.Context, name string) error {
+	if _, err := s.db.ExecContext(ctx, "DROP DATABASE IF EXISTS "+clickhouse.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to drop database %s; %w", name, err)
+	}
+	return nil
+}
+
+func (s *clickhouseServer) UserExists(ctx context.Context, name string) (bool, error) {
+	var count int64
+	if err := s.db.QueryRowContext(ctx, "SELECT count() FROM system.users WHERE name = ?", name).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check user %s; %w", name, err)
+	}
+	return count > 0, nil
+}
+
+func (s *clickhouseServer) CreateUser(ctx context.Context, name string, password string) error {
+	q := fmt.Sprintf("CREATE USER %s IDENTIFIED WITH sha256_password BY %s", clickhouse.QuoteIdentifier(name), clickhouse.QuoteLiteral(password))
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to create user %s; %w", name, err)
+	}
+	return nil
+}
+
+func (s *clickhouseServer) SetPassword(ctx context.Context, name string, password string) error {
+	q := fmt.Sprintf("ALTER USER %s IDENTIFIED WITH sha256_password BY %s", clickhouse.QuoteIdentifier(name), clickhouse.QuoteLiteral(password))
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to set password for user %s; %w", name, err)
+	}
+	return nil
+}
+
+func (s *clickhouseServer) DropUser(ctx context.Context, name string) error {
+	if _, err := s.db.ExecContext(ctx, "DROP USER IF EXISTS "+clickhouse.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to drop user %s; %w", name, err)
+	}
+	return nil
+}
+
+func (s *clickhouseServer) GrantAll(ctx context.Context, database string, user string) error {
+	q := fmt.Sprintf("GRANT ALL ON %s.* TO %s", clickhouse.QuoteIdentifier(database), clickhouse.QuoteIdentifier(user))
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to grant privileges on %s to %s; %w", database, user, err)
+	}
+	return nil
+}
+
+// readOnlyProfile is the settings profile with readonly = 1 shipped in the default config of the server
+const readOnlyProfile = "readonly"
+
+// SetReadOnly adds the readonly settings profile to the user or drops it again. The profile is added next
+// to the ones the user has, so dropping it restores them as they were, and its readonly setting can't be
+// changed by the sessions of the user.
+func (s *clickhouseServer) SetReadOnly(ctx context.Context, _ string, user string, readOnly bool) error {
+	q := fmt.Sprintf("ALTER USER %s DROP PROFILES %s", clickhouse.QuoteIdentifier(user), clickhouse.QuoteLiteral(readOnlyProfile))
+	if readOnly {
+		q = fmt.Sprintf("ALTER USER %s ADD PROFILES %s", clickhouse.QuoteIdentifier(user), clickhouse.QuoteLiteral(readOnlyProfile))
+	}
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to change read only mode of user %s; %w", user, err)
+	}
+	return nil
+}
+
+// TerminateSessions kills the running queries of the user, clickhouse keeps no other sessions
+func (s *clickhouseServer) TerminateSessions(ctx context.Context, user string) error {
+	if _, err := s.db.ExecContext(ctx, "KILL QUERY WHERE user = "+clickhouse.QuoteLiteral(user)+" ASYNC"); err != nil {
+		return fmt.Errorf("failed to kill queries of user %s; %w", user, err)
+	}
+	return nil
+}
+
+func (s *clickhouseServer) DatabaseStats(ctx context.Context, name string) (Stats, error) {
+	stats := Stats{}
+	q := "SELECT toInt64(sum(bytes_on_disk)) FROM system.parts WHERE database = ? AND active"
+	if err := s.db.QueryRowContext(ctx, q, name).Scan(&stats.SizeBytes); err != nil {
+		return Stats{}, fmt.Errorf("failed to get size of database %s; %w", name, err)
+	}
+	q = "SELECT toInt64(count()) FROM system.tables WHERE database = ?"
+	if err := s.db.QueryRowContext(ctx, q, name).Scan(&stats.Tables); err != nil {
+		return Stats{}, fmt.Errorf("failed to count tables of database %s; %w", name, err)
+	}
+	// clickhouse has no notion of a session bound to a database, running queries are the closest match
+	q = "SELECT toInt64(count()) FROM system.processes WHERE current_database = ?"
+	if err := s.db.QueryRowContext(ctx, q, name).Scan(&stats.Connections); err != nil {
+		return Stats{}, fmt.Errorf("failed to count connections of database %s; %w", name, err)
+	}
+	return stats, nil
+}
+
+// CloneDatabase creates the target with a copy of every table of the source, masked columns are replaced while
+// the data is copied. Views and dictionaries are not cloned.
+func (s *clickhouseServer) CloneDatabase(ctx context.Context, source string, target string, masks []Mask) error {
+	if err := s.CreateDatabase(ctx, target); err != nil {
+		return err
+	}
+	q := "SELECT name FROM system.tables WHERE database = ? AND engine NOT IN ('View', 'MaterializedView', 'LiveView', 'Dictionary') AND NOT startsWith(name, '.inner')"
+	rows, err := s.db.QueryContext(ctx, q, source)
+	if err != nil {
+		return fmt.Errorf("failed to list tables of database %s; %w", source, err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to list tables of database %s; %w", source, err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list tables of database %s; %w", source, err)
+	}
+
+	for _, table := range tables {
+		src := clickhouse.QuoteIdentifier(source) + "." + clickhouse.QuoteIdentifier(table)
+		dst := clickhouse.QuoteIdentifier(target) + "." + clickhouse.QuoteIdentifier(table)
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s AS %s", dst, src)); err != nil {
+			return fmt.Errorf("failed to create table %s in %s; %w", table, target, err)
+		}
+		var replaces []string
+		for _, mask := range masks {
+			if mask.Table != table {
+				continue
+			}
+			column := clickhouse.QuoteIdentifier(mask.Column)
+			value := fmt.Sprintf("defaultValueOfArgumentType(%s)", column)
+			if mask.Value != nil {
+				value = clickhouse.QuoteLiteral(*mask.Value)
+			}
+			replaces = append(replaces, value+" AS "+column)
+		}
+		columns := "*"
+		if len(replaces) > 0 {
+			columns = fmt.Sprintf("* REPLACE (%s)", strings.Join(replaces, ", "))
+		}
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s SELECT %s FROM %s", dst, columns, src)); err != nil {
+			return fmt.Errorf("failed to copy table %s to %s; %w", table, target, err)
+		}
+	}
+	return nil
+}
+
+// ReassignOwned does nothing, clickhouse objects have no owner and access is granted per database.
+func (s *clickhouseServer) ReassignOwned(ctx context.Context, database string, from string, to string) error {
+	return nil
+}
+
+// SetOwner does nothing, clickhouse objects have no owner and access is granted per database.
+func (s *clickhouseServer) SetOwner(ctx context.Context, database string, owner string) error {
+	return nil
+}
+
+// Connect opens an administrative connection with the database as the default one
+func (s *clickhouseServer) Connect(ctx context.Context, database string) (*sql.DB, error) {
+	params := s.params
+	params.Database = database
+	return CreateClickhouseSqlConnection(ctx, params)
+}
+
+// ConnectAs opens a connection to the database with the credentials of the user
+func (s *clickhouseServer) ConnectAs(ctx context.Context, database string, user string, password string) (*sql.DB, error) {
+	params := s.params
+	params.User = user
+	params.Password = password
+	params.Database = database
+	return CreateClickhouseSqlConnection(ctx, params)
+}
+
+// errNoSchemas is returned by the schema methods, a clickhouse database is a namespace of tables itself
+var errNoSchemas = errors.New("schemas are not supported by clickhouse")
+
+func (s *clickhouseServer) EnsureSchema(ctx context.Context, database string, schema Schema, user string) error {
+	return errNoSchemas
+}
+
+func (s *clickhouseServer) RevokeSchemaReaders(ctx context.Context, database string, schema Schema, user string) error {
+	return errNoSchemas
+}
+
+func (s *clickhouseServer) DropSchema(ctx context.Context, database string, name string) error {
+	return errNoSchemas
+}
+
+// SetSearchPath accepts no schemas only, there is no search path in clickhouse.
+func (s *clickhouseServer) SetSearchPath(ctx context.Context, database string, user string, schemas []string) error {
+	if len(schemas) > 0 {
+		return errNoSchemas
+	}
+	return nil
+}
+
+func (s *clickhouseServer) EnsureExtension(ctx context.Context, database string, extension Extension) (string, error) {
+	return "", fmt.Errorf("extensions are not supported by clickhouse")
+}
+
+// ListDatabases returns the databases except the system and default ones
+func (s *clickhouseServer) ListDatabases(ctx context.Context) ([]string, error) {
+	q := "SELECT name FROM system.databases WHERE name NOT IN ('system', 'default', 'INFORMATION_SCHEMA', 'information_schema') ORDER BY name"
+	names, err := queryNames(ctx, s.db, q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases; %w", err)
+	}
+	return names, nil
+}
+
+// ListUsers returns the users except the default one
+func (s *clickhouseServer) ListUsers(ctx context.Context) ([]string, error) {
+	names, err := queryNames(ctx, s.db, "SELECT name FROM system.users WHERE name <> 'default' ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users; %w", err)
+	}
+	return names, nil
+}
+
+func (s *clickhouseServer) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,273 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"github.com/slamdev/databaser/pkg/clickhouse"
+	"github.com/slamdev/databaser/pkg/postgres"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Dialect is the SQL flavour of the server the migrations are applied to
+type Dialect string
+
+const (
+	Postgres   Dialect = "postgres"
+	Clickhouse Dialect = "clickhouse"
+)
+
+// DefaultTable keeps the applied migrations unless another table is given
+const DefaultTable = "schema_migrations"
+
+// Migration is a single SQL file, migrations are applied in the order of their versions
+type Migration struct {
+	Version string
+	Name    string
+	SQL     string
+}
+
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.SQL))
+	return hex.EncodeToString(sum[:])
+}
+
+var fileName = regexp.MustCompile(`^([0-9]+)(?:[_-](.*))?\.sql$`)
+
+// Load turns the files named like 0001_create_users.sql into migrations ordered by the version,
+// files without the sql extension are skipped.
+func Load(files map[string]string) ([]Migration, error) {
+	var migrations []Migration
+	versions := map[string]string{}
+	for file, content := range files {
+		if !strings.HasSuffix(file, ".sql") {
+			continue
+		}
+		m := fileName.FindStringSubmatch(file)
+		if m == nil {
+			return nil, fmt.Errorf("file %s is not named as <version>_<name>.sql", file)
+		}
+		version := strings.TrimLeft(m[1], "0")
+		if version == "" {
+			version = "0"
+		}
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("files %s and %s have the same version %s", other, file, version)
+		}
+		versions[version] = file
+		migrations = append(migrations, Migration{Version: version, Name: m[2], SQL: content})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return less(migrations[i].Version, migrations[j].Version)
+	})
+	return migrations, nil
+}
+
+// less compares the numeric versions without leading zeros
+func less(a string, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// Run applies the migrations which are not in the tracking table yet and returns the latest applied
+// version, it stops at the first failure. An applied migration whose content has changed or a new
+// one older than the applied ones fails the run since their order can't be kept.
+func Run(ctx context.Context, db *sql.DB, dialect Dialect, table string, migrations []Migration) (string, error) {
+	if table == "" {
+		table = DefaultTable
+	}
+	t, err := newTracker(dialect, table)
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.ExecContext(ctx, t.create); err != nil {
+		return "", fmt.Errorf("failed to create tracking table %s; %w", table, err)
+	}
+	applied, err := t.applied(ctx, db)
+	if err != nil {
+		return "", fmt.Errorf("failed to read tracking table %s; %w", table, err)
+	}
+
+	latest := ""
+	for version := range applied {
+		if latest == "" || less(latest, version) {
+			latest = version
+		}
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		checksum, ok := applied[m.Version]
+		if !ok {
+			if latest != "" && less(m.Version, latest) {
+				return latest, fmt.Errorf("migration %s is older than the applied version %s", m.Version, latest)
+			}
+			pending = append(pending, m)
+			continue
+		}
+		if checksum != m.Checksum() {
+			return latest, fmt.Errorf("migration %s is changed after it was applied", m.Version)
+		}
+	}
+
+	for _, m := range pending {
+		if err := t.apply(ctx, db, m); err != nil {
+			return latest, fmt.Errorf("failed to apply migration %s; %w", m.Version, err)
+		}
+		latest = m.Version
+	}
+	return latest, nil
+}
+
+// Exec runs the script without tracking it, a postgres script runs in a single implicit transaction
+// unless it controls transactions itself.
+func Exec(ctx context.Context, db *sql.DB, dialect Dialect, script string) error {
+	if dialect != Clickhouse {
+		_, err := db.ExecContext(ctx, script)
+		return err
+	}
+	for _, statement := range SplitStatements(script) {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type tracker struct {
+	create string
+	query  string
+	apply  func(ctx context.Context, db *sql.DB, m Migration) error
+}
+
+func newTracker(dialect Dialect, table string) (tracker, error) {
+	switch dialect {
+	case Postgres:
+		table = postgres.QuoteQualifiedIdentifier(table)
+		insert := fmt.Sprintf("INSERT INTO %s (version, name, checksum) VALUES ($1, $2, $3)", table)
+		return tracker{
+			create: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version text PRIMARY KEY, name text NOT NULL, checksum text NOT NULL, applied_at timestamptz NOT NULL DEFAULT now())", table),
+			query:  fmt.Sprintf("SELECT version, checksum FROM %s", table),
+			// the migration and its record are committed together
+			apply: func(ctx context.Context, db *sql.DB, m Migration) error {
+				tx, err := db.BeginTx(ctx, nil)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+					tx.Rollback()
+					return err
+				}
+				if _, err := tx.ExecContext(ctx, insert, m.Version, m.Name, m.Checksum()); err != nil {
+					tx.Rollback()
+					return err
+				}
+				return tx.Commit()
+			},
+		}, nil
+	case Clickhouse:
+		table = clickhouse.QuoteIdentifier(table)
+		insert := fmt.Sprintf("INSERT INTO %s (version, name, checksum) VALUES (?, ?, ?)", table)
+		return tracker{
+			create: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version String, name String, checksum String, applied_at DateTime DEFAULT now()) ENGINE = MergeTree ORDER BY version", table),
+			query:  fmt.Sprintf("SELECT version, checksum FROM %s", table),
+			// clickhouse has no transactions and runs a single statement per query, a failed
+			// migration may be applied partially
+			apply: func(ctx context.Context, db *sql.DB, m Migration) error {
+				if err := Exec(ctx, db, Clickhouse, m.SQL); err != nil {
+					return err
+				}
+				// the driver sends inserts with values in blocks, which requires a transaction
+				tx, err := db.BeginTx(ctx, nil)
+				if err != nil {
+					return err
+				}
+				stmt, err := tx.PrepareContext(ctx, insert)
+				if err != nil {
+					tx.Rollback()
+					return err
+				}
+				defer stmt.Close()
+				if _, err := stmt.ExecContext(ctx, m.Version, m.Name, m.Checksum()); err != nil {
+					tx.Rollback()
+					return err
+				}
+				return tx.Commit()
+			},
+		}, nil
+	}
+	return tracker{}, fmt.Errorf("dialect %s is not supported", dialect)
+}
+
+func (t tracker) applied(ctx context.Context, db *sql.DB) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, t.query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[string]string{}
+	for rows.Next() {
+		var version, checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, err
+		}
+		applied[version] = checksum
+	}
+	return applied, rows.Err()
+}
+
+// SplitStatements splits the script by semicolons outside of quotes and comments, empty statements are dropped
+func SplitStatements(script string) []string {
+	var statements []string
+	b := strings.Builder{}
+	flush := func() {
+		if s := strings.TrimSpace(b.String()); s != "" {
+			statements = append(statements, s)
+		}
+		b.Reset()
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(script) && script[end] != c {
+				if script[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(script) {
+				end = len(script) - 1
+			}
+			b.WriteString(script[i : end+1])
+			i = end
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end
+				b.WriteByte('\n')
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+		case c == ';':
+			flush()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
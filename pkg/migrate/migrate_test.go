@@ -0,0 +1,191 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(map[string]string{
+		"10_add_index.sql":    "c",
+		"0002-users.sql":      "b",
+		"1.sql":               "a",
+		"README.md":           "skipped",
+		"0003_add_orders.sql": "d",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions, names []string
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+		names = append(names, m.Name)
+	}
+	if want := []string{"1", "2", "3", "10"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("got versions %v, want %v", versions, want)
+	}
+	if want := []string{"", "users", "add_orders", "add_index"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got names %v, want %v", names, want)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"no version":        {"init.sql": ""},
+		"duplicate version": {"1_a.sql": "", "001_b.sql": ""},
+	} {
+		if _, err := Load(files); err == nil {
+			t.Errorf("%s: files are accepted", name)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- users; and more
+CREATE TABLE users (name String DEFAULT 'a;b') ENGINE = Memory;
+/* comment; */ INSERT INTO users VALUES ('it''s; fine', 'x\';y');
+
+SELECT "a;b";`
+	want := []string{
+		"CREATE TABLE users (name String DEFAULT 'a;b') ENGINE = Memory",
+		"INSERT INTO users VALUES ('it''s; fine', 'x\\';y')",
+		`SELECT "a;b"`,
+	}
+	if got := SplitStatements(script); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	d := &fakeDriver{applied: map[string]string{}}
+	db := openFake(t, d)
+	migrations := []Migration{{Version: "1", SQL: "CREATE TABLE a"}, {Version: "2", SQL: "CREATE TABLE b"}}
+
+	version, err := Run(context.Background(), db, Postgres, "", migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "2" {
+		t.Errorf("got version %s, want 2", version)
+	}
+
+	migrations = append(migrations, Migration{Version: "3", SQL: "FAIL"}, Migration{Version: "4", SQL: "CREATE TABLE d"})
+	version, err = Run(context.Background(), db, Postgres, "", migrations)
+	if err == nil || !strings.Contains(err.Error(), "migration 3") {
+		t.Errorf("got error %v, want failure of migration 3", err)
+	}
+	if version != "2" {
+		t.Errorf("got version %s, want 2", version)
+	}
+	if _, ok := d.applied["4"]; ok {
+		t.Error("migration after the failed one is applied")
+	}
+	if got := d.executed["CREATE TABLE a"]; got != 1 {
+		t.Errorf("applied migration is run %d times", got)
+	}
+}
+
+func TestRunRejectsHistoryChanges(t *testing.T) {
+	d := &fakeDriver{applied: map[string]string{"2": Migration{SQL: "CREATE TABLE b"}.Checksum()}}
+	db := openFake(t, d)
+
+	_, err := Run(context.Background(), db, Postgres, "", []Migration{{Version: "2", SQL: "CREATE TABLE c"}})
+	if err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("got error %v, want changed migration", err)
+	}
+	_, err = Run(context.Background(), db, Postgres, "", []Migration{{Version: "1", SQL: "CREATE TABLE a"}, {Version: "2", SQL: "CREATE TABLE b"}})
+	if err == nil || !strings.Contains(err.Error(), "older") {
+		t.Errorf("got error %v, want older migration", err)
+	}
+}
+
+func openFake(t *testing.T, d *fakeDriver) *sql.DB {
+	name := fmt.Sprintf("fake-%s", t.Name())
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// fakeDriver keeps the tracking table in memory and fails the statements containing FAIL
+type fakeDriver struct {
+	applied  map[string]string
+	executed map[string]int
+	pending  map[string]string
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return d, nil }
+func (d *fakeDriver) Close() error                     { return nil }
+func (d *fakeDriver) Begin() (driver.Tx, error) {
+	d.pending = map[string]string{}
+	return d, nil
+}
+func (d *fakeDriver) Prepare(q string) (driver.Stmt, error) { return &fakeStmt{d: d, q: q}, nil }
+
+func (d *fakeDriver) Commit() error {
+	for k, v := range d.pending {
+		d.applied[k] = v
+	}
+	return nil
+}
+
+func (d *fakeDriver) Rollback() error {
+	d.pending = nil
+	return nil
+}
+
+type fakeStmt struct {
+	d *fakeDriver
+	q string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.Contains(s.q, "FAIL") {
+		return nil, fmt.Errorf("syntax error")
+	}
+	if strings.HasPrefix(s.q, "INSERT INTO") {
+		s.d.pending[args[0].(string)] = args[2].(string)
+	} else if !strings.HasPrefix(s.q, "CREATE TABLE IF NOT EXISTS") {
+		if s.d.executed == nil {
+			s.d.executed = map[string]int{}
+		}
+		s.d.executed[s.q]++
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	rows := &fakeRows{}
+	for version, checksum := range s.d.applied {
+		rows.values = append(rows.values, []string{version, checksum})
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	values [][]string
+}
+
+func (r *fakeRows) Columns() []string { return []string{"version", "checksum"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], dest[1] = r.values[0][0], r.values[0][1]
+	r.values = r.values[1:]
+	return nil
+}
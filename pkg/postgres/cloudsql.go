@@ -0,0 +1,55 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultMetadataURL is the token endpoint of the default service account on GCE and GKE with Workload Identity
+const DefaultMetadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// CloudSQLTokenProvider issues OAuth2 access tokens of the service account the operator runs as, Cloud SQL
+// accepts them as the password of the IAM user of the account, named like its email without .gserviceaccount.com.
+type CloudSQLTokenProvider struct {
+	// MetadataURL defaults to DefaultMetadataURL.
+	MetadataURL string
+	Client      *http.Client
+}
+
+func (p *CloudSQLTokenProvider) Token(ctx context.Context, _ Params) (Token, error) {
+	url := p.MetadataURL
+	if url == "" {
+		url = DefaultMetadataURL
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: time.Second * 10}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to request token from metadata server; %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Token{}, fmt.Errorf("metadata server responded with %s", resp.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Token{}, fmt.Errorf("failed to decode token of metadata server; %w", err)
+	}
+	if body.AccessToken == "" {
+		return Token{}, fmt.Errorf("metadata server returned no token")
+	}
+	return Token{Value: body.AccessToken, ExpiresAt: time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)}, nil
+}
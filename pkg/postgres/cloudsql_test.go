@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCloudSQLTokenProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" || r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"access_token":"ya29.token","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	p := &CloudSQLTokenProvider{MetadataURL: server.URL + "/token"}
+	token, err := p.Token(context.Background(), Params{User: "operator@project.iam"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Value != "ya29.token" {
+		t.Errorf("got token %s", token.Value)
+	}
+	if d := time.Until(token.ExpiresAt); d < time.Minute*59 || d > time.Hour {
+		t.Errorf("got token expiring in %s, want in an hour", d)
+	}
+
+	p = &CloudSQLTokenProvider{MetadataURL: server.URL + "/missing"}
+	if _, err := p.Token(context.Background(), Params{}); err == nil {
+		t.Error("got token from a failed response")
+	}
+}
@@ -1,9 +1,13 @@
 package postgres
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	_ "github.com/lib/pq"
+	"github.com/slamdev/databaser/pkg/tunnel"
 	"net/url"
+	"strings"
 )
 
 type Params struct {
@@ -12,6 +16,10 @@ type Params struct {
 	Host     string
 	Port     int
 	AuthDB   string
+	// Issues the password for every new connection when set.
+	TokenProvider TokenProvider
+	// Reaches the host through the bastion when set.
+	Tunnel *tunnel.Config
 }
 
 func DSN(params Params) (string, url.URL) {
@@ -31,3 +39,45 @@ func DSN(params Params) (string, url.URL) {
 	}
 	return "postgres", dbUrl
 }
+
+// MaxIdentifierLength is the number of bytes postgres keeps of an identifier
+const MaxIdentifierLength = 63
+
+// SanitizeIdentifier turns the name into a lower case identifier that needs no quoting. A name longer
+// than MaxIdentifierLength is truncated and suffixed with a hash of the whole name to stay unique.
+func SanitizeIdentifier(name string) string {
+	b := strings.Builder{}
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	s := b.String()
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	if len(s) > MaxIdentifierLength {
+		sum := sha256.Sum256([]byte(name))
+		s = s[:MaxIdentifierLength-9] + "_" + hex.EncodeToString(sum[:])[:8]
+	}
+	return s
+}
+
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteQualifiedIdentifier quotes every part of a name like schema.table
+func QuoteQualifiedIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = QuoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+func QuoteLiteral(value string) string {
+	return `'` + strings.ReplaceAll(value, `'`, `''`) + `'`
+}
@@ -0,0 +1,36 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeIdentifier(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "valid name is kept", in: "team_app", want: "team_app"},
+		{name: "upper case is lowered", in: "Team_App", want: "team_app"},
+		{name: "invalid characters are replaced", in: "team-a.app", want: "team_a_app"},
+		{name: "leading digit is prefixed", in: "1app", want: "_1app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeIdentifier(tt.in)
+			if got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	got := SanitizeIdentifier(long)
+	if len(got) != MaxIdentifierLength || !strings.HasPrefix(got, strings.Repeat("a", 54)+"_") {
+		t.Fatalf("got %s, want a truncated name with hash", got)
+	}
+	if got == SanitizeIdentifier(long+"b") {
+		t.Fatalf("truncated names of different inputs collide")
+	}
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// refreshMargin is how long before the expiry a cached token is replaced
+const refreshMargin = time.Minute
+
+// Token is a short lived password, e.g. an IAM authentication token of RDS or Cloud SQL.
+type Token struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+// TokenProvider issues tokens to log in with instead of a static password.
+type TokenProvider interface {
+	Token(ctx context.Context, params Params) (Token, error)
+}
+
+var (
+	mu             sync.RWMutex
+	tokenProviders = map[string]TokenProvider{}
+)
+
+// RegisterTokenProvider makes the provider available under the name, its tokens are reused until they are
+// about to expire. It panics when the name is taken.
+func RegisterTokenProvider(name string, provider TokenProvider) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := tokenProviders[name]; ok {
+		panic("postgres: token provider " + name + " is registered twice")
+	}
+	tokenProviders[name] = &cachingTokenProvider{provider: provider, tokens: map[string]Token{}}
+}
+
+// GetTokenProvider returns the provider registered under the name.
+func GetTokenProvider(name string) (TokenProvider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	provider, ok := tokenProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("token provider %s is not registered", name)
+	}
+	return provider, nil
+}
+
+// cachingTokenProvider reuses a token of the user of an instance until it is about to expire
+type cachingTokenProvider struct {
+	provider TokenProvider
+	mu       sync.Mutex
+	tokens   map[string]Token
+}
+
+func (p *cachingTokenProvider) Token(ctx context.Context, params Params) (Token, error) {
+	key := params.User + "@" + params.Host + ":" + strconv.Itoa(params.Port)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if token, ok := p.tokens[key]; ok && time.Now().Add(refreshMargin).Before(token.ExpiresAt) {
+		return token, nil
+	}
+	token, err := p.provider.Token(ctx, params)
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to get token for %s; %w", key, err)
+	}
+	p.tokens[key] = token
+	return token, nil
+}
+
+// FakeTokenProvider issues numbered tokens valid for TTL, it is meant for tests.
+type FakeTokenProvider struct {
+	TTL time.Duration
+
+	mu     sync.Mutex
+	issued int
+}
+
+func (p *FakeTokenProvider) Token(ctx context.Context, params Params) (Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.issued++
+	return Token{Value: fmt.Sprintf("token-%d", p.issued), ExpiresAt: time.Now().Add(p.TTL)}, nil
+}
+
+// Issued returns the number of tokens issued so far.
+func (p *FakeTokenProvider) Issued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.issued
+}
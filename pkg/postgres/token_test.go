@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCachingTokenProvider(t *testing.T) {
+	ctx := context.Background()
+	params := Params{User: "admin", Host: "db", Port: 5432}
+
+	fake := &FakeTokenProvider{TTL: time.Hour}
+	p := &cachingTokenProvider{provider: fake, tokens: map[string]Token{}}
+	first, err := p.Token(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.Token(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Value != second.Value || fake.Issued() != 1 {
+		t.Fatalf("got %s and %s after %d tokens, want a reused token", first.Value, second.Value, fake.Issued())
+	}
+	if _, err := p.Token(ctx, Params{User: "other", Host: "db", Port: 5432}); err != nil || fake.Issued() != 2 {
+		t.Fatalf("got %d tokens, %v, want a token per user", fake.Issued(), err)
+	}
+
+	expiring := &FakeTokenProvider{TTL: refreshMargin / 2}
+	p = &cachingTokenProvider{provider: expiring, tokens: map[string]Token{}}
+	first, _ = p.Token(ctx, params)
+	second, _ = p.Token(ctx, params)
+	if first.Value == second.Value || expiring.Issued() != 2 {
+		t.Fatalf("got %s and %s, want a refreshed token before the expiry", first.Value, second.Value)
+	}
+}
@@ -0,0 +1,438 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/slamdev/databaser/pkg/postgres"
+	"strings"
+)
+
+type postgresServer struct {
+	db     *sql.DB
+	params postgres.Params
+}
+
+func (s *postgresServer) DatabaseExists(ctx context.Context, name string) (bool, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM pg_database WHERE datname = $1)", name).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check database %s; %w", name, err)
+	}
+	return exists, nil
+}
+
+func (s *postgresServer) CreateDatabase(ctx context.Context, name string) error {
+	if _, err := s.db.ExecContext(ctx, "CREATE DATABASE "+postgres.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to create database %s; %w", name, err)
+	}
+	return nil
+}
+
+func (s *postgresServer) DropDatabase(ctx context.Context, name string) error {
+	if _, err := s.db.ExecContext(ctx, "DROP DATABASE IF EXISTS "+postgres.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to drop database %s; %w", name, err)
+	}
+	return nil
+}
+
+func (s *postgresServer) UserExists(ctx context.Context, name string) (bool, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM pg_roles WHERE rolname = $1)", name).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check user %s; %w", name, err)
+	}
+	return exists, nil
+}
+
+func (s *postgresServer) CreateUser(ctx context.Context, name string, password string) error {
+	q := fmt.Sprintf("CREATE ROLE %s LOGIN PASSWORD %s", postgres.QuoteIdentifier(name), postgres.QuoteLiteral(password))
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to create user %s; %w", name, err)
+	}
+	return nil
+}
+
+func (s *postgresServer) SetPassword(ctx context.Context, name string, password string) error {
+	q := fmt.Sprintf("ALTER ROLE %s PASSWORD %s", postgres.QuoteIdentifier(name), postgres.QuoteLiteral(password))
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to set password for user %s; %w", name, err)
+	}
+	return nil
+}
+
+func (s *postgresServer) DropUser(ctx context.Context, name string) error {
+	if _, err := s.db.ExecContext(ctx, "DROP ROLE IF EXISTS "+postgres.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to drop user %s; %w", name, err)
+	}
+	return nil
+}
+
+func (s *postgresServer) GrantAll(ctx context.Context, database string, user string) error {
+	q := fmt.Sprintf("GRANT ALL PRIVILEGES ON DATABASE %s TO %s", postgres.QuoteIdentifier(database), postgres.QuoteIdentifier(user))
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to grant privileges on %s to %s; %w", database, user, err)
+	}
+	return nil
+}
+
+// SetReadOnly revokes the privileges to write the tables owned by the user and to create objects in the
+// database, or grants them back. Privileges on schemas the user doesn't own are restored by EnsureSchema.
+// The revokes are the enforcement, default_transaction_read_only is only a hint to the clients since any
+// session can turn it off. The user stays the owner of the tables and could grant the privileges back to
+// itself, so it stops the writes of the applications rather than a hostile user.
+func (s *postgresServer) SetReadOnly(ctx context.Context, database string, user string, readOnly bool) error {
+	role := postgres.QuoteIdentifier(user)
+	statements := []string{
+		fmt.Sprintf("ALTER ROLE %s SET default_transaction_read_only = on", role),
+		fmt.Sprintf("REVOKE CREATE, TEMPORARY ON DATABASE %s FROM %s", postgres.QuoteIdentifier(database), role),
+	}
+	q := `SELECT format('REVOKE CREATE ON SCHEMA %I FROM %I', nspname, $1::text) FROM pg_namespace
+WHERE has_schema_privilege($1::text, oid, 'CREATE') AND nspname NOT LIKE 'pg\_%' AND nspname <> 'information_schema'
+UNION ALL
+SELECT format('REVOKE INSERT, UPDATE, DELETE, TRUNCATE ON %I.%I FROM %I', n.nspname, c.relname, $1::text)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p', 'f') AND pg_get_userbyid(c.relowner) = $1`
+	if !readOnly {
+		statements = []string{
+			fmt.Sprintf("ALTER ROLE %s RESET default_transaction_read_only", role),
+			fmt.Sprintf("GRANT CREATE, TEMPORARY ON DATABASE %s TO %s", postgres.QuoteIdentifier(database), role),
+		}
+		q = `SELECT format('GRANT CREATE ON SCHEMA %I TO %I', nspname, $1::text) FROM pg_namespace
+WHERE pg_get_userbyid(nspowner) = $1 AND nspname NOT LIKE 'pg\_%' AND nspname <> 'information_schema'
+UNION ALL
+SELECT format('GRANT INSERT, UPDATE, DELETE, TRUNCATE ON %I.%I TO %I', n.nspname, c.relname, $1::text)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p', 'f') AND pg_get_userbyid(c.relowner) = $1`
+	}
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to change read only mode of user %s; %w", user, err)
+		}
+	}
+	err := s.withDatabase(ctx, database, func(db *sql.DB) error {
+		return execGenerated(ctx, db, q, user)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to change read only mode of user %s in %s; %w", user, database, err)
+	}
+	return nil
+}
+
+// TerminateSessions disconnects the user from every database
+func (s *postgresServer) TerminateSessions(ctx context.Context, user string) error {
+	q := "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE usename = $1 AND pid <> pg_backend_pid()"
+	if _, err := s.db.ExecContext(ctx, q, user); err != nil {
+		return fmt.Errorf("failed to terminate sessions of user %s; %w", user, err)
+	}
+	return nil
+}
+
+func (s *postgresServer) DatabaseStats(ctx context.Context, name string) (Stats, error) {
+	stats := Stats{}
+	q := "SELECT pg_database_size(datname), numbackends FROM pg_stat_database WHERE datname = $1"
+	if err := s.db.QueryRowContext(ctx, q, name).Scan(&stats.SizeBytes, &stats.Connections); err != nil {
+		return Stats{}, fmt.Errorf("failed to get stats of database %s; %w", name, err)
+	}
+	// tables are only visible from a connection to the database itself
+	err := s.withDatabase(ctx, name, func(db *sql.DB) error {
+		q := "SELECT count(*) FROM information_schema.tables WHERE table_schema NOT IN ('pg_catalog', 'information_schema')"
+		return db.QueryRowContext(ctx, q).Scan(&stats.Tables)
+	})
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to count tables of database %s; %w", name, err)
+	}
+	return stats, nil
+}
+
+// CloneDatabase copies the source with CREATE DATABASE ... TEMPLATE, which requires the source to have
+// no other sessions, so they are terminated first.
+func (s *postgresServer) CloneDatabase(ctx context.Context, source string, target string, masks []Mask) error {
+	q := "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()"
+	if _, err := s.db.ExecContext(ctx, q, source); err != nil {
+		return fmt.Errorf("failed to terminate connections to database %s; %w", source, err)
+	}
+	q = fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", postgres.QuoteIdentifier(target), postgres.QuoteIdentifier(source))
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to clone database %s to %s; %w", source, target, err)
+	}
+	if len(masks) == 0 {
+		return nil
+	}
+	// the masks are applied together, a failure leaves no column half masked
+	err := s.withDatabase(ctx, target, func(db *sql.DB) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, mask := range masks {
+			value := "NULL"
+			if mask.Value != nil {
+				value = postgres.QuoteLiteral(*mask.Value)
+			}
+			q := fmt.Sprintf("UPDATE %s SET %s = %s", postgres.QuoteQualifiedIdentifier(mask.Table), postgres.QuoteIdentifier(mask.Column), value)
+			if _, err := tx.ExecContext(ctx, q); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to mask %s.%s; %w", mask.Table, mask.Column, err)
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mask database %s; %w", target, err)
+	}
+	return nil
+}
+
+// ReassignOwned transfers the schemas and relations of the database owned by one user to another one. Unlike
+// REASSIGN OWNED it leaves alone the objects shared by the cluster, the database itself among them.
+func (s *postgresServer) ReassignOwned(ctx context.Context, database string, from string, to string) error {
+	err := s.withDatabase(ctx, database, func(db *sql.DB) error {
+		return execGenerated(ctx, db, alterOwnerQuery, to, from)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reassign objects of %s in %s to %s; %w", from, database, to, err)
+	}
+	return nil
+}
+
+// SetOwner makes the user the owner of the database, its schemas and relations. Sequences owned by
+// a column follow the owner of their table and objects of extensions stay as they are.
+func (s *postgresServer) SetOwner(ctx context.Context, database string, owner string) error {
+	q := fmt.Sprintf("ALTER DATABASE %s OWNER TO %s", postgres.QuoteIdentifier(database), postgres.QuoteIdentifier(owner))
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to change owner of database %s to %s; %w", database, owner, err)
+	}
+	err := s.withDatabase(ctx, database, func(db *sql.DB) error {
+		return execGenerated(ctx, db, alterOwnerQuery, owner, "")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to change owner of objects in %s to %s; %w", database, owner, err)
+	}
+	return nil
+}
+
+// alterOwnerQuery generates the statements changing the owner of the schemas and relations to $1, only of
+// the ones owned by $2 unless it is empty
+const alterOwnerQuery = `SELECT format('ALTER SCHEMA %I OWNER TO %I', nspname, $1::text) FROM pg_namespace
+WHERE nspname NOT LIKE 'pg\_%' AND nspname <> 'information_schema'
+  AND ($2::text = '' OR pg_get_userbyid(nspowner) = $2::text)
+UNION ALL
+SELECT format('ALTER %s %I.%I OWNER TO %I',
+  CASE c.relkind WHEN 'v' THEN 'VIEW' WHEN 'm' THEN 'MATERIALIZED VIEW' WHEN 'S' THEN 'SEQUENCE' WHEN 'f' THEN 'FOREIGN TABLE' ELSE 'TABLE' END,
+  n.nspname, c.relname, $1::text)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p', 'v', 'm', 'S', 'f')
+  AND n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'
+  AND ($2::text = '' OR pg_get_userbyid(c.relowner) = $2::text)
+  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype IN ('a', 'i', 'e'))`
+
+// ListDatabases returns the databases except templates and the default postgres one
+func (s *postgresServer) ListDatabases(ctx context.Context) ([]string, error) {
+	names, err := queryNames(ctx, s.db, "SELECT datname FROM pg_database WHERE NOT datistemplate AND datname <> 'postgres' ORDER BY datname")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases; %w", err)
+	}
+	return names, nil
+}
+
+// ListUsers returns the roles allowed to log in except the predefined ones
+func (s *postgresServer) ListUsers(ctx context.Context) ([]string, error) {
+	names, err := queryNames(ctx, s.db, "SELECT rolname FROM pg_roles WHERE rolcanlogin AND rolname NOT LIKE 'pg\\_%' ORDER BY rolname")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users; %w", err)
+	}
+	return names, nil
+}
+
+// EnsureSchema creates the schema owned by the owner and lets the user, when it is not the owner, create
+// objects in it. Readers get select on the present tables and on the future ones created by both roles.
+func (s *postgresServer) EnsureSchema(ctx context.Context, database string, schema Schema, user string) error {
+	name := postgres.QuoteIdentifier(schema.Name)
+	owner := postgres.QuoteIdentifier(schema.Owner)
+	statements := []string{
+		fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s AUTHORIZATION %s", name, owner),
+		fmt.Sprintf("ALTER SCHEMA %s OWNER TO %s", name, owner),
+	}
+	if schema.Owner != user {
+		statements = append(statements, fmt.Sprintf("GRANT USAGE, CREATE ON SCHEMA %s TO %s", name, postgres.QuoteIdentifier(user)))
+	}
+	for _, reader := range schema.Readers {
+		reader = postgres.QuoteIdentifier(reader)
+		statements = append(statements,
+			fmt.Sprintf("GRANT USAGE ON SCHEMA %s TO %s", name, reader),
+			fmt.Sprintf("GRANT SELECT ON ALL TABLES IN SCHEMA %s TO %s", name, reader),
+		)
+		for _, creator := range schemaCreators(schema, user) {
+			statements = append(statements, fmt.Sprintf("ALTER DEFAULT PRIVILEGES FOR ROLE %s IN SCHEMA %s GRANT SELECT ON TABLES TO %s", creator, name, reader))
+		}
+	}
+	if err := s.execInDatabase(ctx, database, statements); err != nil {
+		return fmt.Errorf("failed to provision schema %s in %s; %w", schema.Name, database, err)
+	}
+	return nil
+}
+
+// RevokeSchemaReaders takes back what EnsureSchema granted to the readers of the schema
+func (s *postgresServer) RevokeSchemaReaders(ctx context.Context, database string, schema Schema, user string) error {
+	name := postgres.QuoteIdentifier(schema.Name)
+	var statements []string
+	for _, reader := range schema.Readers {
+		reader = postgres.QuoteIdentifier(reader)
+		for _, creator := range schemaCreators(schema, user) {
+			statements = append(statements, fmt.Sprintf("ALTER DEFAULT PRIVILEGES FOR ROLE %s IN SCHEMA %s REVOKE SELECT ON TABLES FROM %s", creator, name, reader))
+		}
+		statements = append(statements,
+			fmt.Sprintf("REVOKE SELECT ON ALL TABLES IN SCHEMA %s FROM %s", name, reader),
+			fmt.Sprintf("REVOKE USAGE ON SCHEMA %s FROM %s", name, reader),
+		)
+	}
+	if err := s.execInDatabase(ctx, database, statements); err != nil {
+		return fmt.Errorf("failed to revoke access to schema %s in %s; %w", schema.Name, database, err)
+	}
+	return nil
+}
+
+// schemaCreators returns the quoted roles which may create tables in the schema
+func schemaCreators(schema Schema, user string) []string {
+	creators := []string{postgres.QuoteIdentifier(schema.Owner)}
+	if schema.Owner != user {
+		creators = append(creators, postgres.QuoteIdentifier(user))
+	}
+	return creators
+}
+
+// DropSchema drops the schema together with its objects
+func (s *postgresServer) DropSchema(ctx context.Context, database string, name string) error {
+	if err := s.execInDatabase(ctx, database, []string{"DROP SCHEMA IF EXISTS " + postgres.QuoteIdentifier(name) + " CASCADE"}); err != nil {
+		return fmt.Errorf("failed to drop schema %s in %s; %w", name, database, err)
+	}
+	return nil
+}
+
+// SetSearchPath puts the schemas before public on the search path of the user in the database, no schemas
+// restore the default. It takes effect on the next login.
+func (s *postgresServer) SetSearchPath(ctx context.Context, database string, user string, schemas []string) error {
+	q := fmt.Sprintf("ALTER ROLE %s IN DATABASE %s RESET search_path", postgres.QuoteIdentifier(user), postgres.QuoteIdentifier(database))
+	if len(schemas) > 0 {
+		var path []string
+		for _, schema := range schemas {
+			path = append(path, postgres.QuoteIdentifier(schema))
+		}
+		path = append(path, "public")
+		q = fmt.Sprintf("ALTER ROLE %s IN DATABASE %s SET search_path = %s", postgres.QuoteIdentifier(user), postgres.QuoteIdentifier(database), strings.Join(path, ", "))
+	}
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to set search path of user %s in %s; %w", user, database, err)
+	}
+	return nil
+}
+
+// EnsureExtension installs the extension or brings the installed one to the version and schema,
+// it returns the installed version.
+func (s *postgresServer) EnsureExtension(ctx context.Context, database string, extension Extension) (string, error) {
+	var version string
+	err := s.withDatabase(ctx, database, func(db *sql.DB) error {
+		q := `SELECT a.default_version, coalesce(e.extversion, ''), coalesce(n.nspname, '')
+FROM pg_available_extensions a
+LEFT JOIN pg_extension e ON e.extname = a.name
+LEFT JOIN pg_namespace n ON n.oid = e.extnamespace
+WHERE a.name = $1`
+		var defaultVersion, installed, schema string
+		if err := db.QueryRowContext(ctx, q, extension.Name).Scan(&defaultVersion, &installed, &schema); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("extension is not available on the server")
+			}
+			return err
+		}
+		target := extension.Version
+		if target == "" {
+			target = defaultVersion
+		}
+		name := postgres.QuoteIdentifier(extension.Name)
+		var statements []string
+		if installed == "" {
+			statement := "CREATE EXTENSION IF NOT EXISTS " + name
+			if extension.Schema != "" {
+				statement += " SCHEMA " + postgres.QuoteIdentifier(extension.Schema)
+			}
+			statements = append(statements, statement+" VERSION "+postgres.QuoteLiteral(target))
+		} else {
+			if installed != target {
+				statements = append(statements, fmt.Sprintf("ALTER EXTENSION %s UPDATE TO %s", name, postgres.QuoteLiteral(target)))
+			}
+			if extension.Schema != "" && extension.Schema != schema {
+				statements = append(statements, fmt.Sprintf("ALTER EXTENSION %s SET SCHEMA %s", name, postgres.QuoteIdentifier(extension.Schema)))
+			}
+		}
+		for _, statement := range statements {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+		return db.QueryRowContext(ctx, "SELECT extversion FROM pg_extension WHERE extname = $1", extension.Name).Scan(&version)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to install extension %s in %s; %w", extension.Name, database, err)
+	}
+	return version, nil
+}
+
+// Connect opens an administrative connection to the database
+func (s *postgresServer) Connect(ctx context.Context, database string) (*sql.DB, error) {
+	params := s.params
+	params.AuthDB = database
+	return CreatePostgresSqlConnection(ctx, params)
+}
+
+// ConnectAs opens a connection to the database with the password of the user, the token provider
+// of the admin doesn't apply to it
+func (s *postgresServer) ConnectAs(ctx context.Context, database string, user string, password string) (*sql.DB, error) {
+	params := s.params
+	params.User = user
+	params.Password = password
+	params.AuthDB = database
+	params.TokenProvider = nil
+	return CreatePostgresSqlConnection(ctx, params)
+}
+
+func (s *postgresServer) Close() error {
+	return s.db.Close()
+}
+
+func (s *postgresServer) withDatabase(ctx context.Context, name string, f func(db *sql.DB) error) error {
+	params := s.params
+	params.AuthDB = name
+	c, err := CreatePostgresSqlConnection(ctx, params)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return f(c)
+}
+
+func (s *postgresServer) execInDatabase(ctx context.Context, database string, statements []string) error {
+	if len(statements) == 0 {
+		return nil
+	}
+	return s.withDatabase(ctx, database, func(db *sql.DB) error {
+		for _, statement := range statements {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// execGenerated runs the statements returned by the query
+func execGenerated(ctx context.Context, db *sql.DB, q string, args ...interface{}) error {
+	statements, err := queryNames(ctx, db, q, args...)
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package retention
+
+import (
+	"sort"
+	"time"
+)
+
+// Policy defines which backups survive pruning, zero values disable the rule
+type Policy struct {
+	// KeepLast keeps the given number of the most recent backups.
+	KeepLast int
+	// KeepDaily keeps the most recent backup of each of the given number of last days.
+	KeepDaily int
+}
+
+// Select returns indexes of the backups created at the given times that the policy keeps.
+// All backups are kept when the policy has no rules.
+func Select(created []time.Time, now time.Time, policy Policy) map[int]bool {
+	keep := map[int]bool{}
+	order := make([]int, len(created))
+	for i := range order {
+		order[i] = i
+	}
+	if policy.KeepLast <= 0 && policy.KeepDaily <= 0 {
+		for _, i := range order {
+			keep[i] = true
+		}
+		return keep
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return created[order[a]].After(created[order[b]])
+	})
+	for n, i := range order {
+		if n < policy.KeepLast {
+			keep[i] = true
+		}
+	}
+
+	if policy.KeepDaily > 0 {
+		today := day(now)
+		oldest := today.AddDate(0, 0, -(policy.KeepDaily - 1))
+		seen := map[time.Time]bool{}
+		for _, i := range order {
+			d := day(created[i])
+			if d.Before(oldest) || seen[d] {
+				continue
+			}
+			seen[d] = true
+			keep[i] = true
+		}
+	}
+	return keep
+}
+
+func day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
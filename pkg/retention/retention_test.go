@@ -0,0 +1,42 @@
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelect(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	created := []time.Time{
+		now.Add(-1 * time.Hour),      // 0: today, newest
+		now.Add(-2 * time.Hour),      // 1: today
+		now.Add(-26 * time.Hour),     // 2: yesterday
+		now.Add(-27 * time.Hour),     // 3: yesterday
+		now.Add(-5 * 24 * time.Hour), // 4: five days ago
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		want   []int
+	}{
+		{name: "no rules keep everything", policy: Policy{}, want: []int{0, 1, 2, 3, 4}},
+		{name: "keep last", policy: Policy{KeepLast: 2}, want: []int{0, 1}},
+		{name: "keep daily", policy: Policy{KeepDaily: 2}, want: []int{0, 2}},
+		{name: "keep daily covers older days", policy: Policy{KeepDaily: 7}, want: []int{0, 2, 4}},
+		{name: "rules are combined", policy: Policy{KeepLast: 2, KeepDaily: 2}, want: []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Select(created, now, tt.policy)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for _, i := range tt.want {
+				if !got[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileProvider reads values from files below a directory, e.g. mounted by the Secrets Store CSI driver.
+// A secret is a file with a single value or a directory with a file per key. Instances of a namespace
+// can only read below the directory named after the namespace.
+type FileProvider struct {
+	Dir string
+}
+
+func (p *FileProvider) Value(ctx context.Context, scope string, name string, key string) (string, error) {
+	if !isPlainName(name) || (key != "" && !isPlainName(key)) || (scope != "" && !isPlainName(scope)) {
+		return "", fmt.Errorf("invalid secret reference %s/%s", name, key)
+	}
+	path := filepath.Join(p.Dir, scope, name, key)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return "", fmt.Errorf("%s is a directory; %w", path, ErrNotFound)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to read %s; %w", path, ErrNotFound)
+		}
+		return "", fmt.Errorf("failed to read %s; %w", path, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// isPlainName rejects names that could escape the directory
+func isPlainName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,62 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(path string, value string) {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(value), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("admin/password", "secret\n")
+	write("host", "db.example.com")
+	write("team/admin/password", "team-secret")
+
+	tests := []struct {
+		name  string
+		scope string
+		ref   string
+		key   string
+		want  string
+		err   error
+	}{
+		{name: "key of a directory", ref: "admin", key: "password", want: "secret"},
+		{name: "single value file", ref: "host", want: "db.example.com"},
+		{name: "scoped to namespace", scope: "team", ref: "admin", key: "password", want: "team-secret"},
+		{name: "missing key", ref: "admin", key: "username", err: ErrNotFound},
+		{name: "directory is not a value", ref: "admin", err: ErrNotFound},
+		{name: "other namespace is not reachable", scope: "team", ref: "host", err: ErrNotFound},
+		{name: "parent directory is rejected", scope: "team", ref: "..", key: "host"},
+	}
+	p := &FileProvider{Dir: dir}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Value(context.Background(), tt.scope, tt.ref, tt.key)
+			if tt.want == "" {
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Fatalf("got %q, %v, want error %v", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNotFound is returned by a provider when the requested value doesn't exist.
+var ErrNotFound = errors.New("secret value not found")
+
+// Provider reads values kept outside of the cluster, e.g. in files or a secret manager.
+type Provider interface {
+	// Value returns the key of the named secret, an empty key means the secret is a single value.
+	// The scope is the namespace of the instance asking for the value and is empty for a cluster instance,
+	// a provider should not let a scope read values of another one.
+	Value(ctx context.Context, scope string, name string, key string) (string, error)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{}
+)
+
+// Register makes the provider available under the name, it panics when the name is taken.
+func Register(name string, provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := providers[name]; ok {
+		panic("secrets: provider " + name + " is registered twice")
+	}
+	providers[name] = provider
+}
+
+// Get returns the provider registered under the name.
+func Get(name string) (Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("secret provider %s is not registered", name)
+	}
+	return provider, nil
+}
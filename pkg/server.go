@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+)
+
+// Server is an administrative connection to a database instance.
+type Server interface {
+	DatabaseExists(ctx context.Context, name string) (bool, error)
+	CreateDatabase(ctx context.Context, name string) error
+	DropDatabase(ctx context.Context, name string) error
+	UserExists(ctx context.Context, name string) (bool, error)
+	CreateUser(ctx context.Context, name string, password string) error
+	SetPassword(ctx context.Context, name string, password string) error
+	DropUser(ctx context.Context, name string) error
+	GrantAll(ctx context.Context, database string, user string) error
+	SetReadOnly(ctx context.Context, database string, user string, readOnly bool) error
+	TerminateSessions(ctx context.Context, user string) error
+	DatabaseStats(ctx context.Context, name string) (Stats, error)
+	CloneDatabase(ctx context.Context, source string, target string, masks []Mask) error
+	ReassignOwned(ctx context.Context, database string, from string, to string) error
+	SetOwner(ctx context.Context, database string, owner string) error
+	ListDatabases(ctx context.Context) ([]string, error)
+	ListUsers(ctx context.Context) ([]string, error)
+	EnsureSchema(ctx context.Context, database string, schema Schema, user string) error
+	RevokeSchemaReaders(ctx context.Context, database string, schema Schema, user string) error
+	DropSchema(ctx context.Context, database string, name string) error
+	SetSearchPath(ctx context.Context, database string, user string, schemas []string) error
+	EnsureExtension(ctx context.Context, database string, extension Extension) (string, error)
+	Connect(ctx context.Context, database string) (*sql.DB, error)
+	ConnectAs(ctx context.Context, database string, user string, password string) (*sql.DB, error)
+	Close() error
+}
+
+// Stats is a snapshot of the storage and activity of a single database.
+type Stats struct {
+	SizeBytes   int64
+	Tables      int64
+	Connections int64
+}
+
+// Mask replaces all values of a column while cloning a database, a nil Value resets them.
+type Mask struct {
+	Table  string
+	Column string
+	Value  *string
+}
+
+// Schema is a schema of a database with the owner and the users reading its tables.
+type Schema struct {
+	Name    string
+	Owner   string
+	Readers []string
+}
+
+// Extension is an extension of a database, empty Version and Schema leave them to the server.
+type Extension struct {
+	Name    string
+	Version string
+	Schema  string
+}
+
+func queryNames(ctx context.Context, db *sql.DB, q string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func GeneratePassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate password; %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -3,23 +3,76 @@ package pkg
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"github.com/lib/pq"
 	"github.com/slamdev/databaser/pkg/clickhouse"
 	"github.com/slamdev/databaser/pkg/postgres"
+	"github.com/slamdev/databaser/pkg/tunnel"
 	"net/url"
 )
 
 func CreatePostgresSqlConnection(ctx context.Context, params postgres.Params) (*sql.DB, error) {
+	if params.TokenProvider != nil {
+		c := sql.OpenDB(&tokenConnector{params: params})
+		if err := c.PingContext(ctx); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to ping connection; %w", err)
+		}
+		return c, nil
+	}
 	d, u := postgres.DSN(params)
-	return createSqlConnection(ctx, d, u)
+	return createSqlConnection(ctx, d, u, params.Tunnel)
+}
+
+// tokenConnector logs in with a token of the provider, so every new connection of the pool uses a valid one
+type tokenConnector struct {
+	params postgres.Params
+}
+
+func (c *tokenConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, err := c.params.TokenProvider.Token(ctx, c.params)
+	if err != nil {
+		return nil, err
+	}
+	params := c.params
+	params.Password = token.Value
+	_, u := postgres.DSN(params)
+	if err := forward(ctx, &u, params.Tunnel); err != nil {
+		return nil, err
+	}
+	return c.Driver().Open(u.String())
+}
+
+func (c *tokenConnector) Driver() driver.Driver {
+	return &pq.Driver{}
 }
 
 func CreateClickhouseSqlConnection(ctx context.Context, params clickhouse.Params) (*sql.DB, error) {
 	d, u := clickhouse.DSN(params)
-	return createSqlConnection(ctx, d, u)
+	return createSqlConnection(ctx, d, u, params.Tunnel)
+}
+
+func ConnectPostgres(ctx context.Context, params postgres.Params) (Server, error) {
+	c, err := CreatePostgresSqlConnection(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresServer{db: c, params: params}, nil
+}
+
+func ConnectClickhouse(ctx context.Context, params clickhouse.Params) (Server, error) {
+	c, err := CreateClickhouseSqlConnection(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return &clickhouseServer{db: c, params: params}, nil
 }
 
-func createSqlConnection(ctx context.Context, driver string, dsn url.URL) (*sql.DB, error) {
+func createSqlConnection(ctx context.Context, driver string, dsn url.URL, t *tunnel.Config) (*sql.DB, error) {
+	if err := forward(ctx, &dsn, t); err != nil {
+		return nil, err
+	}
 	c, err := sql.Open(driver, dsn.String())
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to instance; %w", err)
@@ -29,3 +82,17 @@ func createSqlConnection(ctx context.Context, driver string, dsn url.URL) (*sql.
 	}
 	return c, nil
 }
+
+// forward points the dsn to the local end of the tunnel, the params keep the real host
+// so the tokens and the credentials given to the applications still refer to it
+func forward(ctx context.Context, dsn *url.URL, t *tunnel.Config) error {
+	if t == nil {
+		return nil
+	}
+	addr, err := tunnel.Forward(ctx, *t, dsn.Host)
+	if err != nil {
+		return fmt.Errorf("failed to open ssh tunnel; %w", err)
+	}
+	dsn.Host = addr
+	return nil
+}
@@ -0,0 +1,250 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config describes the bastion the tunnel goes through
+type Config struct {
+	Host       string
+	Port       int
+	User       string
+	PrivateKey string
+	// Content of a known_hosts file the bastion key is verified against.
+	KnownHosts string
+}
+
+// IdleTimeout is how long a tunnel without connections is kept open for the next use
+var IdleTimeout = 10 * time.Minute
+
+var (
+	tunnelsMu sync.Mutex
+	tunnels   = map[string]*tunnel{}
+)
+
+// Forward returns the local address forwarded to the target through the bastion. The tunnel is kept
+// open and shared by the calls with the same config and target, unused tunnels are closed on the way.
+func Forward(ctx context.Context, config Config, target string) (string, error) {
+	key := tunnelKey(config, target)
+	tunnelsMu.Lock()
+	closeIdle(key)
+	t, ok := tunnels[key]
+	if !ok {
+		var err error
+		if t, err = open(config, target); err != nil {
+			tunnelsMu.Unlock()
+			return "", err
+		}
+		tunnels[key] = t
+	}
+	t.track(0)
+	tunnelsMu.Unlock()
+	// connect eagerly so a broken bastion fails the caller instead of the first query
+	if _, err := t.sshClient(ctx); err != nil {
+		return "", err
+	}
+	return t.listener.Addr().String(), nil
+}
+
+// closeIdle closes the tunnels other than the kept one which have no connections for IdleTimeout
+func closeIdle(keep string) {
+	for key, t := range tunnels {
+		if key != keep && t.idle() {
+			t.close()
+			delete(tunnels, key)
+		}
+	}
+}
+
+func tunnelKey(config Config, target string) string {
+	h := sha256.New()
+	for _, s := range []string{config.Host, strconv.Itoa(config.Port), config.User, config.PrivateKey, config.KnownHosts, target} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type tunnel struct {
+	address   string
+	sshConfig *ssh.ClientConfig
+	target    string
+	listener  net.Listener
+
+	// dialMu serializes dialing the bastion, mu guards the state
+	dialMu   sync.Mutex
+	mu       sync.Mutex
+	client   *ssh.Client
+	active   int
+	lastUsed time.Time
+}
+
+func open(config Config, target string) (*tunnel, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(config.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh private key; %w", err)
+	}
+	hostKeyCallback, err := parseKnownHosts(config.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+	port := config.Port
+	if port == 0 {
+		port = 22
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for tunnel; %w", err)
+	}
+	t := &tunnel{
+		address: net.JoinHostPort(config.Host, strconv.Itoa(port)),
+		sshConfig: &ssh.ClientConfig{
+			User:            config.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+		target:   target,
+		listener: l,
+		lastUsed: time.Now(),
+	}
+	go t.serve()
+	return t, nil
+}
+
+// parseKnownHosts builds the host key callback, knownhosts reads files only so the content goes through one
+func parseKnownHosts(content string) (ssh.HostKeyCallback, error) {
+	if content == "" {
+		return nil, fmt.Errorf("known hosts are required to verify the bastion")
+	}
+	f, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write known hosts; %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write known hosts; %w", err)
+	}
+	callback, err := knownhosts.New(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse known hosts; %w", err)
+	}
+	return callback, nil
+}
+
+func (t *tunnel) serve() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+func (t *tunnel) forward(conn net.Conn) {
+	defer conn.Close()
+	c, err := t.sshClient(context.Background())
+	if err != nil {
+		return
+	}
+	remote, err := c.Dial("tcp", t.target)
+	if err != nil {
+		// the session is likely dead, the next connection dials the bastion again
+		t.reset(c)
+		return
+	}
+	defer remote.Close()
+	t.track(1)
+	defer t.track(-1)
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// sshClient returns the connected client, dialing the bastion when there is none
+func (t *tunnel) sshClient(ctx context.Context) (*ssh.Client, error) {
+	t.dialMu.Lock()
+	defer t.dialMu.Unlock()
+	t.mu.Lock()
+	c := t.client
+	t.mu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	d := net.Dialer{Timeout: t.sshConfig.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", t.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to bastion %s; %w", t.address, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, t.address, t.sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to establish ssh connection to %s; %w", t.address, err)
+	}
+	c = ssh.NewClient(sshConn, chans, reqs)
+	t.mu.Lock()
+	t.client = c
+	t.mu.Unlock()
+	go func() {
+		c.Wait()
+		t.reset(c)
+	}()
+	return c, nil
+}
+
+// reset drops the client if it is still the current one
+func (t *tunnel) reset(c *ssh.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == c {
+		t.client = nil
+		c.Close()
+	}
+}
+
+func (t *tunnel) track(delta int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active += delta
+	t.lastUsed = time.Now()
+}
+
+func (t *tunnel) idle() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active == 0 && time.Since(t.lastUsed) > IdleTimeout
+}
+
+func (t *tunnel) close() {
+	t.listener.Close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		t.client.Close()
+		t.client = nil
+	}
+}
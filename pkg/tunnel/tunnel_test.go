@@ -0,0 +1,197 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// fakeBastion accepts the client key and forwards direct-tcpip channels, it counts the ssh logins
+type fakeBastion struct {
+	address string
+	hostKey ssh.Signer
+	logins  int32
+}
+
+func startBastion(t *testing.T, clientKey ssh.PublicKey) *fakeBastion {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	b := &fakeBastion{address: l.Addr().String(), hostKey: hostKey}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() != "jump" || string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, io.EOF
+			}
+			atomic.AddInt32(&b.logins, 1)
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn, config)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBastion) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for ch := range chans {
+		if ch.ChannelType() != "direct-tcpip" {
+			_ = ch.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		msg := struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}{}
+		_ = ssh.Unmarshal(ch.ExtraData(), &msg)
+		target, err := net.Dial("tcp", net.JoinHostPort(msg.Host, strconv.Itoa(int(msg.Port))))
+		if err != nil {
+			_ = ch.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		c, creqs, _ := ch.Accept()
+		go ssh.DiscardRequests(creqs)
+		go func() {
+			defer c.Close()
+			defer target.Close()
+			go io.Copy(target, c)
+			io.Copy(c, target)
+		}()
+	}
+}
+
+// startEcho starts the server the tunnel leads to
+func startEcho(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func testConfig(t *testing.T) (Config, *fakeBastion) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := startBastion(t, signer.PublicKey())
+	host, port, _ := net.SplitHostPort(b.address)
+	config := Config{
+		Host:       host,
+		User:       "jump",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		KnownHosts: knownhosts.Line([]string{b.address}, b.hostKey.PublicKey()) + "\n",
+	}
+	config.Port, _ = strconv.Atoi(port)
+	return config, b
+}
+
+func echo(t *testing.T, addr, msg string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != msg {
+		t.Errorf("got %q, want %q", buf, msg)
+	}
+}
+
+func TestForward(t *testing.T) {
+	config, b := testConfig(t)
+	target := startEcho(t)
+
+	addr, err := Forward(context.Background(), config, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, addr, "ping")
+	echo(t, addr, "pong")
+
+	again, err := Forward(context.Background(), config, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != addr {
+		t.Errorf("tunnel is not reused, got %s, want %s", again, addr)
+	}
+	echo(t, again, "ping")
+	if n := atomic.LoadInt32(&b.logins); n != 1 {
+		t.Errorf("got %d logins, want 1", n)
+	}
+}
+
+func TestForwardUnknownHost(t *testing.T) {
+	config, _ := testConfig(t)
+	other, _ := testConfig(t)
+	config.KnownHosts = other.KnownHosts
+
+	if _, err := Forward(context.Background(), config, startEcho(t)); err == nil {
+		t.Error("bastion with an unknown host key is accepted")
+	}
+}
+
+func TestForwardWithoutKnownHosts(t *testing.T) {
+	config, _ := testConfig(t)
+	config.KnownHosts = ""
+
+	if _, err := Forward(context.Background(), config, startEcho(t)); err == nil {
+		t.Error("bastion is accepted without known hosts")
+	}
+}
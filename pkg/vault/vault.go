@@ -0,0 +1,214 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/slamdev/databaser/pkg/secrets"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Client reads and writes secrets of a KV version 2 engine. It logs in with the Kubernetes auth
+// method using the token of the service account unless a static Token is given, e.g. for a dev server.
+type Client struct {
+	// Address of the server, e.g. http://127.0.0.1:8200.
+	Address string
+	// Token used as is instead of the Kubernetes login.
+	Token string
+	// Role of the Kubernetes auth method.
+	Role string
+	// Mount of the Kubernetes auth method, defaults to kubernetes.
+	AuthMount string
+	// Mount of the KV engine, defaults to secret.
+	KVMount string
+	// Service account token, defaults to the one mounted into the pod.
+	TokenPath string
+	HTTP      *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Read returns the latest version of the secret at the path, nil when there is none.
+func (c *Client) Read(ctx context.Context, path string) (map[string]string, error) {
+	out := struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}{}
+	found, err := c.do(ctx, http.MethodGet, c.kvPath("data", path), nil, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s; %w", path, err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return out.Data.Data, nil
+}
+
+// Write stores the data as a new version of the secret at the path.
+func (c *Client) Write(ctx context.Context, path string, data map[string]string) error {
+	in := map[string]interface{}{"data": data}
+	if _, err := c.do(ctx, http.MethodPost, c.kvPath("data", path), in, nil); err != nil {
+		return fmt.Errorf("failed to write %s; %w", path, err)
+	}
+	return nil
+}
+
+// Delete removes every version of the secret at the path.
+func (c *Client) Delete(ctx context.Context, path string) error {
+	if _, err := c.do(ctx, http.MethodDelete, c.kvPath("metadata", path), nil, nil); err != nil {
+		return fmt.Errorf("failed to delete %s; %w", path, err)
+	}
+	return nil
+}
+
+// Value implements secrets.Provider, the secrets of a namespaced instance are read below the namespace.
+func (c *Client) Value(ctx context.Context, scope string, name string, key string) (string, error) {
+	path := name
+	if scope != "" {
+		path = scope + "/" + name
+	}
+	if strings.Contains(path, "..") {
+		return "", fmt.Errorf("invalid secret reference %s", name)
+	}
+	data, err := c.Read(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	val, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s of %s; %w", key, path, secrets.ErrNotFound)
+	}
+	return val, nil
+}
+
+func (c *Client) kvPath(kind string, path string) string {
+	mount := c.KVMount
+	if mount == "" {
+		mount = "secret"
+	}
+	return fmt.Sprintf("/v1/%s/%s/%s", mount, kind, strings.TrimPrefix(path, "/"))
+}
+
+// do sends the request and decodes the response into out, it reports false when nothing is found at the path
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) (bool, error) {
+	token, err := c.login(ctx)
+	if err != nil {
+		return false, err
+	}
+	res, err := c.send(ctx, method, path, token, in)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode == http.StatusForbidden {
+		// the token may be revoked before it expires, the next request logs in again
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+	}
+	if res.StatusCode >= 300 {
+		return false, responseError(res)
+	}
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return true, nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("failed to decode response; %w", err)
+	}
+	return true, nil
+}
+
+// login returns a token, logging in with the Kubernetes auth method when the previous one is about to expire
+func (c *Client) login(ctx context.Context) (string, error) {
+	if c.Token != "" {
+		return c.Token, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	tokenPath := c.TokenPath
+	if tokenPath == "" {
+		tokenPath = defaultTokenPath
+	}
+	jwt, err := ioutil.ReadFile(tokenPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read service account token; %w", err)
+	}
+	mount := c.AuthMount
+	if mount == "" {
+		mount = "kubernetes"
+	}
+	in := map[string]string{"role": c.Role, "jwt": strings.TrimSpace(string(jwt))}
+	res, err := c.send(ctx, http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", mount), "", in)
+	if err != nil {
+		return "", fmt.Errorf("failed to login; %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return "", fmt.Errorf("failed to login; %w", responseError(res))
+	}
+	out := struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("failed to decode login response; %w", err)
+	}
+	c.token = out.Auth.ClientToken
+	// renew ahead of the expiry to not use a token that expires in flight
+	c.expires = time.Now().Add(time.Duration(out.Auth.LeaseDuration) * time.Second * 9 / 10)
+	return c.token, nil
+}
+
+func (c *Client) send(ctx context.Context, method string, path string, token string, in interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.Address, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req)
+}
+
+func responseError(res *http.Response) error {
+	out := struct {
+		Errors []string `json:"errors"`
+	}{}
+	_ = json.NewDecoder(res.Body).Decode(&out)
+	return fmt.Errorf("vault responded with %d: %s", res.StatusCode, strings.Join(out.Errors, "; "))
+}
@@ -0,0 +1,101 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/slamdev/databaser/pkg/secrets"
+)
+
+// fakeVault mimics the kubernetes auth method and a KV version 2 engine mounted at secret
+func fakeVault(t *testing.T) *httptest.Server {
+	kv := map[string]map[string]string{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/kubernetes/login" {
+			in := map[string]string{}
+			_ = json.NewDecoder(r.Body).Decode(&in)
+			if in["role"] != "databaser" || in["jwt"] != "sa-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"vault-token","lease_duration":3600}}`))
+			return
+		}
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+			path := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+			switch r.Method {
+			case http.MethodGet:
+				data, ok := kv[path]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data}})
+			case http.MethodPost:
+				in := struct {
+					Data map[string]string `json:"data"`
+				}{}
+				_ = json.NewDecoder(r.Body).Decode(&in)
+				kv[path] = in.Data
+				_, _ = w.Write([]byte(`{}`))
+			}
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/") && r.Method == http.MethodDelete:
+			delete(kv, strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+func TestClient(t *testing.T) {
+	server := fakeVault(t)
+	defer server.Close()
+	tokenFile, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tokenFile.Name())
+	if _, err := tokenFile.WriteString("sa-token\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	c := &Client{Address: server.URL, Role: "databaser", TokenPath: tokenFile.Name()}
+
+	data, err := c.Read(ctx, "team/app")
+	if err != nil || data != nil {
+		t.Fatalf("got %v, %v, want nothing", data, err)
+	}
+	if err := c.Write(ctx, "team/app", map[string]string{"password": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	val, err := c.Value(ctx, "team", "app", "password")
+	if err != nil || val != "secret" {
+		t.Fatalf("got %q, %v, want secret", val, err)
+	}
+	if _, err := c.Value(ctx, "team", "app", "username"); !errors.Is(err, secrets.ErrNotFound) {
+		t.Fatalf("got %v, want not found", err)
+	}
+	if _, err := c.Value(ctx, "other", "app", "password"); !errors.Is(err, secrets.ErrNotFound) {
+		t.Fatalf("got %v, want not found", err)
+	}
+	if err := c.Delete(ctx, "team/app"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Read(ctx, "team/app"); err != nil || data != nil {
+		t.Fatalf("got %v, %v, want nothing", data, err)
+	}
+}